@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"inventory-service/config"
+	"inventory-service/internal/product"
+	productplacement "inventory-service/internal/product_placement"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/reconciliation"
+	shelftype "inventory-service/internal/shelf_type"
+	"inventory-service/internal/storage"
+	"inventory-service/pkg/constants"
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// reconcile compares the ledger with the placements and shelf capacities,
+// prints the run as JSON and exits with status 1 when mismatches are left
+// unrepaired.
+func main() {
+	repair := flag.Bool("repair", false, "fix placements and total stock to match the ledger")
+	token := flag.String("token", os.Getenv("PRODUCT_SERVICE_TOKEN"), "bearer token for product-service, which -repair asks which products are serialized")
+	flag.Parse()
+
+	if _, err := os.Stat(".env"); err == nil {
+		if err := godotenv.Load(); err != nil {
+			log.Printf("Warning: Error loading .env file: %v", err)
+		}
+	}
+
+	cfg := config.LoadConfig()
+
+	mongoClient, err := connectToMongoDB(cfg.MongoURI)
+	if err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer mongoClient.Disconnect(context.Background())
+
+	// Only repairs look products up, so a report-only run needs no
+	// product-service.
+	productService := product.NewStubProductService()
+	if *repair && !cfg.ProductServiceStub {
+		consulClient, err := api.NewClient(&api.Config{Address: fmt.Sprintf("%s:%s", cfg.Consul.Host, cfg.Consul.Port)})
+		if err != nil {
+			log.Fatalf("Failed to create Consul client: %v", err)
+		}
+		productService = product.NewProductService(consulClient)
+	}
+
+	database := mongoClient.Database(cfg.MongoDB)
+	service := reconciliation.NewReconciliationService(
+		reconciliation.NewReconciliationRepository(database.Collection("reconciliation"), database.Collection("reconciliation_repair")),
+		producttransaction.NewProductTransactionRepository(database.Collection("product_transaction"), database.Collection("document")),
+		productplacement.NewProductPlacementRepository(database.Collection("product_placement")),
+		storage.NewStorageRepository(database.Collection("storage")),
+		shelftype.NewShelfTypeRepository(database.Collection("shelf_type")),
+		productService,
+		storage.NewTypeRules(cfg.StorageTypes),
+		mongoClient,
+	)
+
+	ctx := context.WithValue(context.Background(), constants.TokenKey, *token)
+	run, err := service.Reconcile(ctx, *repair, "reconcile")
+	if err != nil {
+		log.Fatalf("Failed to reconcile stock: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(run); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+
+	if run.MismatchCount > run.Repaired {
+		os.Exit(1)
+	}
+}
+
+func connectToMongoDB(uri string) (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, err
+	}
+
+	return client, nil
+}
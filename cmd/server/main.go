@@ -4,11 +4,18 @@ import (
 	"context"
 	"inventory-service/config"
 
-	// "inventory-service/internal/product"
+	cyclecount "inventory-service/internal/cycle_count"
+	"inventory-service/internal/label"
+	"inventory-service/internal/product"
 	productplacement "inventory-service/internal/product_placement"
 	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/reconciliation"
+	"inventory-service/internal/reservation"
+	"inventory-service/internal/scan"
+	"inventory-service/internal/serial"
 	shelfquantity "inventory-service/internal/shelf_quantity"
 	shelftype "inventory-service/internal/shelf_type"
+	stockhistory "inventory-service/internal/stock_history"
 	"inventory-service/internal/storage"
 	"inventory-service/pkg/consul"
 	"inventory-service/pkg/uploader"
@@ -71,16 +78,41 @@ func main() {
 	}()
 
 	imageService := uploader.NewImageService(consulClient)
+	typeRules := storage.NewTypeRules(cfg.StorageTypes)
 
 	shelfTypeCollection := mongoClient.Database(cfg.MongoDB).Collection("shelf_type")
 	storageCollection := mongoClient.Database(cfg.MongoDB).Collection("storage")
 	productTransaction := mongoClient.Database(cfg.MongoDB).Collection("product_transaction")
 	productPlacement := mongoClient.Database(cfg.MongoDB).Collection("product_placement")
 	shelfQuantityCollection := mongoClient.Database(cfg.MongoDB).Collection("shelf_quantity")
+	cycleCountCollection := mongoClient.Database(cfg.MongoDB).Collection("cycle_count")
+	cycleCountLineCollection := mongoClient.Database(cfg.MongoDB).Collection("cycle_count_line")
+	serialCollection := mongoClient.Database(cfg.MongoDB).Collection("serial")
+	reservationCollection := mongoClient.Database(cfg.MongoDB).Collection("reservation")
+	reservationLockCollection := mongoClient.Database(cfg.MongoDB).Collection("reservation_lock")
+	stockSnapshotCollection := mongoClient.Database(cfg.MongoDB).Collection("stock_snapshot")
+	stockSnapshotLineCollection := mongoClient.Database(cfg.MongoDB).Collection("stock_snapshot_line")
+	reconciliationCollection := mongoClient.Database(cfg.MongoDB).Collection("reconciliation")
+	reconciliationRepairCollection := mongoClient.Database(cfg.MongoDB).Collection("reconciliation_repair")
+	documentCollection := mongoClient.Database(cfg.MongoDB).Collection("document")
 	shelfTypeRepository := shelftype.NewShelfTypeRepository(shelfTypeCollection)
 	storageRepository := storage.NewStorageRepository(storageCollection)
-	productTransactionRepository := producttransaction.NewProductTransactionRepository(productTransaction)
+	productTransactionRepository := producttransaction.NewProductTransactionRepository(productTransaction, documentCollection)
+	if err := productTransactionRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create product transaction indexes: %v", err)
+	}
 	productPlacementRepository := productplacement.NewProductPlacementRepository(productPlacement)
+	if err := productPlacementRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create product placement indexes: %v", err)
+	}
+	serialRepository := serial.NewSerialRepository(serialCollection)
+	if err := serialRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create serial indexes: %v", err)
+	}
+	reservationRepository := reservation.NewReservationRepository(reservationCollection, reservationLockCollection)
+	if err := reservationRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create reservation indexes: %v", err)
+	}
 
 	shelfQuantityRepository := shelfquantity.NewShelfQuantityRepository(shelfQuantityCollection)
 	shelfQuantityService := shelfquantity.NewShelfQuantityService(shelfQuantityRepository, storageRepository)
@@ -89,21 +121,81 @@ func main() {
 	shelfTypeService := shelftype.NewShelfTypeService(shelfTypeRepository, storageRepository, imageService)
 	shelfTypeHandler := shelftype.NewShelfTypeHandler(shelfTypeService)
 
-	// productService := product.NewProductService(consulClient)
-	storageService := storage.NewStorageService(storageRepository, shelfTypeRepository, shelfQuantityRepository, imageService)
+	var productService product.ProductService
+	if cfg.ProductServiceStub {
+		log.Println("Using stub product service")
+		productService = product.NewStubProductService()
+	} else {
+		productService = product.NewProductService(consulClient)
+	}
+	storageService := storage.NewStorageService(storageRepository, shelfTypeRepository, shelfQuantityRepository, productPlacementRepository, imageService, mongoClient, typeRules)
 	storageHandler := storage.NewStorageHandler(storageService)
 
-	productPlacementService := productplacement.NewProductPlacementService(productPlacementRepository, storageRepository)
+	if cfg.StorageArchiveRetentionDays > 0 {
+		retention := time.Duration(cfg.StorageArchiveRetentionDays) * 24 * time.Hour
+		go storage.RunPurgeJob(context.Background(), storageService, retention, time.Hour)
+	}
+
+	productPlacementService := productplacement.NewProductPlacementService(productPlacementRepository, storageRepository, typeRules)
 	productPlacementHandler := productplacement.NewProductPlacementHandler(productPlacementService)
-	productTransactionService := producttransaction.NewProductTransactionService(productTransactionRepository, productPlacementService, mongoClient)
+	reservationService := reservation.NewReservationService(reservationRepository, productPlacementRepository, storageRepository, time.Duration(cfg.ReservationTTLMinutes)*time.Minute, mongoClient)
+	reservationHandler := reservation.NewReservationHandler(reservationService)
+	go reservation.RunExpiryJob(context.Background(), reservationService, time.Minute)
+
+	productTransactionService := producttransaction.NewProductTransactionService(productTransactionRepository, productPlacementService, storageRepository, productService, serialRepository, reservationService, mongoClient)
 	productTransactionHandler := producttransaction.NewProductTransactionHandler(productTransactionService)
 
+	scanService := scan.NewScanService(storageRepository, shelfQuantityRepository, productPlacementRepository, typeRules)
+	scanHandler := scan.NewScanHandler(scanService)
+
+	serialService := serial.NewSerialService(serialRepository, storageRepository)
+	serialHandler := serial.NewSerialHandler(serialService)
+
+	labelService := label.NewLabelService(storageRepository, shelfQuantityRepository)
+	labelHandler := label.NewLabelHandler(labelService)
+
+	cycleCountRepository := cyclecount.NewCycleCountRepository(cycleCountCollection, cycleCountLineCollection)
+	if err := cycleCountRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create cycle count indexes: %v", err)
+	}
+	cycleCountService := cyclecount.NewCycleCountService(cycleCountRepository, storageRepository, productPlacementRepository, productTransactionRepository, reservationService, shelfQuantityRepository, productService, typeRules, mongoClient)
+	cycleCountHandler := cyclecount.NewCycleCountHandler(cycleCountService)
+
+	stockHistoryRepository := stockhistory.NewStockHistoryRepository(stockSnapshotCollection, stockSnapshotLineCollection)
+	if err := stockHistoryRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create stock snapshot indexes: %v", err)
+	}
+	stockHistoryService := stockhistory.NewStockHistoryService(stockHistoryRepository, productTransactionRepository, storageRepository)
+	stockHistoryHandler := stockhistory.NewStockHistoryHandler(stockHistoryService)
+
+	if cfg.StockSnapshotIntervalHours > 0 {
+		go stockhistory.RunSnapshotJob(context.Background(), stockHistoryService, time.Duration(cfg.StockSnapshotIntervalHours)*time.Hour)
+	}
+
+	reconciliationRepository := reconciliation.NewReconciliationRepository(reconciliationCollection, reconciliationRepairCollection)
+	if err := reconciliationRepository.EnsureIndexes(context.Background()); err != nil {
+		log.Fatalf("Failed to create reconciliation indexes: %v", err)
+	}
+	reconciliationService := reconciliation.NewReconciliationService(reconciliationRepository, productTransactionRepository, productPlacementRepository, storageRepository, shelfTypeRepository, productService, typeRules, mongoClient)
+	reconciliationHandler := reconciliation.NewReconciliationHandler(reconciliationService)
+
+	if cfg.ReconciliationIntervalHours > 0 {
+		go reconciliation.RunReconcileJob(context.Background(), reconciliationService, time.Duration(cfg.ReconciliationIntervalHours)*time.Hour)
+	}
+
 	r := gin.Default()
 	shelftype.RegisterRoutes(r, shelfTypeHandler)
 	storage.RegisterRoutes(r, storageHandler)
 	productplacement.RegisterRoutes(r, productPlacementHandler)
 	producttransaction.RegisterRoutes(r, productTransactionHandler)
 	shelfquantity.RegisterRoutes(r, shelfQuantityHandler)
+	scan.RegisterRoutes(r, scanHandler)
+	label.RegisterRoutes(r, labelHandler)
+	cyclecount.RegisterRoutes(r, cycleCountHandler)
+	serial.RegisterRoutes(r, serialHandler)
+	reservation.RegisterRoutes(r, reservationHandler)
+	stockhistory.RegisterRoutes(r, stockHistoryHandler)
+	reconciliation.RegisterRoutes(r, reconciliationHandler)
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8009"
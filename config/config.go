@@ -1,6 +1,12 @@
 package config
 
-import "os"
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/spf13/viper"
+)
 
 type Consul struct {
 	Host string `mapstructure:"host" validate:"required"`
@@ -47,14 +53,40 @@ type ZapConfig struct {
 	} `mapstructure:"cores"`
 }
 
+// StorageTypeRule describes where a storage type may sit in the hierarchy and
+// what it is allowed to carry.
+type StorageTypeRule struct {
+	Type           string   `mapstructure:"type"`
+	ParentTypes    []string `mapstructure:"parent_types"`
+	AllowRoot      bool     `mapstructure:"allow_root"`
+	AllowShelfType bool     `mapstructure:"allow_shelf_type"`
+	AllowPlacement bool     `mapstructure:"allow_placement"`
+}
+
 type Config struct {
-	Port     string
-	MongoURI string
-	MongoDB  string
-	Consul   Consul           `mapstructure:"consul" validate:"required"`
-	Registry Registry         `mapstructure:"registry" validate:"required"`
-	App      AppConfiguration `mapstructure:"app"`
-	Zap      ZapConfig        `mapstructure:"zap"`
+	Port         string
+	MongoURI     string
+	MongoDB      string
+	Consul       Consul            `mapstructure:"consul" validate:"required"`
+	Registry     Registry          `mapstructure:"registry" validate:"required"`
+	App          AppConfiguration  `mapstructure:"app"`
+	Zap          ZapConfig         `mapstructure:"zap"`
+	StorageTypes []StorageTypeRule `mapstructure:"storage_types"`
+
+	// Days an archived storage is kept before the purge job hard deletes it, 0 disables purging
+	StorageArchiveRetentionDays int
+
+	// Use an in-memory product lookup instead of calling product-service
+	ProductServiceStub bool
+
+	// Minutes a reservation holds stock when the request sets no expiry
+	ReservationTTLMinutes int
+
+	// Hours between stock snapshots used by as-of queries, 0 disables snapshots
+	StockSnapshotIntervalHours int
+
+	// Hours between report-only ledger reconciliations, 0 disables the job
+	ReconciliationIntervalHours int
 }
 
 func LoadConfig() *Config {
@@ -99,12 +131,75 @@ func LoadConfig() *Config {
 			},
 		},
 	}
+
+	storageTypes, err := loadStorageTypes(getEnv("STORAGE_TYPE_RULES", ""))
+	if err != nil {
+		log.Fatalf("Failed to load storage type rules: %v", err)
+	}
+	config.StorageTypes = storageTypes
+	config.StorageArchiveRetentionDays = getEnvInt("STORAGE_ARCHIVE_RETENTION_DAYS", 0)
+	config.ProductServiceStub = getEnv("PRODUCT_SERVICE_STUB", "false") == "true"
+	config.ReservationTTLMinutes = getEnvInt("RESERVATION_TTL_MINUTES", 60)
+	config.StockSnapshotIntervalHours = getEnvInt("STOCK_SNAPSHOT_INTERVAL_HOURS", 24)
+	config.ReconciliationIntervalHours = getEnvInt("RECONCILIATION_INTERVAL_HOURS", 0)
+
 	return config
 }
 
+func defaultStorageTypes() []StorageTypeRule {
+	return []StorageTypeRule{
+		{Type: "warehouse", AllowRoot: true},
+		{Type: "building", ParentTypes: []string{"warehouse"}},
+		{Type: "floor", ParentTypes: []string{"building"}},
+		{Type: "room", ParentTypes: []string{"floor"}},
+		{Type: "shelf", ParentTypes: []string{"room"}, AllowShelfType: true, AllowPlacement: true},
+	}
+}
+
+// loadStorageTypes reads the storage_types list from a yaml/json file. An empty
+// path keeps the default warehouse > building > floor > room > shelf chain.
+func loadStorageTypes(path string) ([]StorageTypeRule, error) {
+
+	if path == "" {
+		return defaultStorageTypes(), nil
+	}
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+
+	var rules []StorageTypeRule
+	if err := v.UnmarshalKey("storage_types", &rules); err != nil {
+		return nil, err
+	}
+
+	if len(rules) == 0 {
+		return defaultStorageTypes(), nil
+	}
+
+	return rules, nil
+}
+
 func getEnv(key, defaultValue string) string {
 	if value, exists := os.LookupEnv(key); exists {
 		return value
 	}
 	return defaultValue
 }
+
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid value %q for %s, using %d", value, key, defaultValue)
+		return defaultValue
+	}
+
+	return parsed
+}
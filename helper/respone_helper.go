@@ -7,6 +7,7 @@ import (
 const (
 	ErrInvalidOperation = "ERR_INVALID_OPERATION"
 	ErrInvalidRequest   = "ERR_INVALID_REQUEST"
+	ErrConflict         = "ERR_CONFLICT"
 )
 
 type APIResponse struct {
@@ -0,0 +1,231 @@
+package cyclecount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inventory-service/helper"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/reservation"
+	"inventory-service/pkg/constants"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CycleCountHandler struct {
+	CycleCountService CycleCountService
+}
+
+func NewCycleCountHandler(cycleCountService CycleCountService) *CycleCountHandler {
+	return &CycleCountHandler{
+		CycleCountService: cycleCountService,
+	}
+}
+
+func (h *CycleCountHandler) CreateCycleCount(c *gin.Context) {
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	var req CreateCycleCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	count, err := h.CycleCountService.CreateCycleCount(ctx, &req, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Create cycle count successfully", count)
+
+}
+
+func (h *CycleCountHandler) GetCycleCounts(c *gin.Context) {
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	query := &GetCycleCountsQuery{
+		Page:      page,
+		Size:      size,
+		Status:    c.Query("status"),
+		StorageID: c.Query("storage_id"),
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	counts, err := h.CycleCountService.GetCycleCounts(ctx, query)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get cycle counts successfully", counts)
+
+}
+
+func (h *CycleCountHandler) GetCycleCountByID(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	count, err := h.CycleCountService.GetCycleCountByID(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get cycle count successfully", count)
+
+}
+
+func (h *CycleCountHandler) RecordCount(c *gin.Context) {
+
+	id := c.Param("id")
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	var req RecordCountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	line, err := h.CycleCountService.RecordCount(ctx, id, &req, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Record count successfully", line)
+
+}
+
+func (h *CycleCountHandler) ApproveCycleCount(c *gin.Context) {
+
+	id := c.Param("id")
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	result, err := h.CycleCountService.ApproveCycleCount(ctx, id, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Approve cycle count successfully", result)
+
+}
+
+func (h *CycleCountHandler) CancelCycleCount(c *gin.Context) {
+
+	id := c.Param("id")
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	if err := h.CycleCountService.CancelCycleCount(ctx, id, userID.(string)); err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Cancel cycle count successfully", nil)
+
+}
+
+func isConflict(err error) bool {
+	return errors.Is(err, ErrCountClosed) ||
+		errors.Is(err, ErrShelfAlreadyFrozen) ||
+		errors.Is(err, ErrStockChanged) ||
+		errors.Is(err, ErrSerializedVariance) ||
+		errors.Is(err, reservation.ErrNotAvailable) ||
+		errors.Is(err, producttransaction.ErrDocumentNumberUsed)
+}
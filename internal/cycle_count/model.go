@@ -0,0 +1,40 @@
+package cyclecount
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StatusOpen      = "OPEN"
+	StatusApproved  = "APPROVED"
+	StatusCancelled = "CANCELLED"
+)
+
+type CycleCount struct {
+	ID        primitive.ObjectID   `json:"id" bson:"_id"`
+	StorageID primitive.ObjectID   `json:"storage_id" bson:"storage_id"`
+	Path      string               `json:"path" bson:"path"`
+	ShelfIDs  []primitive.ObjectID `json:"shelf_ids" bson:"shelf_ids"`
+	Freeze    bool                 `json:"freeze" bson:"freeze"`
+	Status    string               `json:"status" bson:"status"`
+	Note      string               `json:"note" bson:"note"`
+	CreatedBy string               `json:"created_by" bson:"created_by"`
+	ClosedBy  string               `json:"closed_by,omitempty" bson:"closed_by,omitempty"`
+	ClosedAt  *time.Time           `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	CreatedAt time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at" bson:"updated_at"`
+}
+
+// CountLine is the quantity of one product counted on one shelf. Recording
+// the same product and shelf again replaces the earlier count.
+type CountLine struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	CountID    primitive.ObjectID `json:"count_id" bson:"count_id"`
+	ShelfID    primitive.ObjectID `json:"shelf_id" bson:"shelf_id"`
+	ProductID  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	CountedQty int                `json:"counted_qty" bson:"counted_qty"`
+	CountedBy  string             `json:"counted_by" bson:"counted_by"`
+	CountedAt  time.Time          `json:"counted_at" bson:"counted_at"`
+}
@@ -0,0 +1,163 @@
+package cyclecount
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type CycleCountFilter struct {
+	Status    string
+	StorageID *primitive.ObjectID
+	Skip      int64
+	Limit     int64
+}
+
+type CycleCountRepository interface {
+	CreateCycleCount(ctx context.Context, count *CycleCount) error
+	GetCycleCountByID(ctx context.Context, id primitive.ObjectID) (*CycleCount, error)
+	GetCycleCounts(ctx context.Context, filter *CycleCountFilter) ([]*CycleCount, int64, error)
+	CloseCycleCount(ctx context.Context, id primitive.ObjectID, status, userID string, at time.Time) (bool, error)
+	UpsertLine(ctx context.Context, line *CountLine) error
+	GetLines(ctx context.Context, countID primitive.ObjectID) ([]*CountLine, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+type cycleCountRepository struct {
+	collection     *mongo.Collection
+	lineCollection *mongo.Collection
+}
+
+func NewCycleCountRepository(collection, lineCollection *mongo.Collection) CycleCountRepository {
+	return &cycleCountRepository{
+		collection:     collection,
+		lineCollection: lineCollection,
+	}
+}
+
+func (r *cycleCountRepository) CreateCycleCount(ctx context.Context, count *CycleCount) error {
+
+	_, err := r.collection.InsertOne(ctx, count)
+	return err
+
+}
+
+func (r *cycleCountRepository) GetCycleCountByID(ctx context.Context, id primitive.ObjectID) (*CycleCount, error) {
+
+	var count CycleCount
+
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&count)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &count, nil
+
+}
+
+func (r *cycleCountRepository) GetCycleCounts(ctx context.Context, filter *CycleCountFilter) ([]*CycleCount, int64, error) {
+
+	query := bson.M{}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.StorageID != nil {
+		query["storage_id"] = filter.StorageID
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(filter.Skip).
+		SetLimit(filter.Limit)
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	counts := []*CycleCount{}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, 0, err
+	}
+
+	return counts, total, nil
+
+}
+
+// CloseCycleCount moves an open count to status. It reports false when the
+// count is no longer open, so approve and cancel cannot both succeed.
+func (r *cycleCountRepository) CloseCycleCount(ctx context.Context, id primitive.ObjectID, status, userID string, at time.Time) (bool, error) {
+
+	filter := bson.M{"_id": id, "status": StatusOpen}
+	update := bson.M{"$set": bson.M{
+		"status":     status,
+		"closed_by":  userID,
+		"closed_at":  at,
+		"updated_at": at,
+	}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+
+}
+
+func (r *cycleCountRepository) UpsertLine(ctx context.Context, line *CountLine) error {
+
+	filter := bson.M{"count_id": line.CountID, "shelf_id": line.ShelfID, "product_id": line.ProductID}
+	update := bson.M{
+		"$set": bson.M{
+			"counted_qty": line.CountedQty,
+			"counted_by":  line.CountedBy,
+			"counted_at":  line.CountedAt,
+		},
+		"$setOnInsert": bson.M{"_id": line.ID},
+	}
+
+	_, err := r.lineCollection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+
+}
+
+func (r *cycleCountRepository) GetLines(ctx context.Context, countID primitive.ObjectID) ([]*CountLine, error) {
+
+	cursor, err := r.lineCollection.Find(ctx, bson.M{"count_id": countID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var lines []*CountLine
+	if err := cursor.All(ctx, &lines); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+
+}
+
+func (r *cycleCountRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := r.lineCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "count_id", Value: 1}, {Key: "shelf_id", Value: 1}, {Key: "product_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+
+}
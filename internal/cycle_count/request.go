@@ -0,0 +1,23 @@
+package cyclecount
+
+type CreateCycleCountRequest struct {
+	StorageID string `json:"storage_id" binding:"required"`
+	Freeze    bool   `json:"freeze"`
+	Note      string `json:"note"`
+}
+
+// RecordCountRequest identifies the shelf either by id or by a scanned
+// storage or inventory QR code.
+type RecordCountRequest struct {
+	ShelfID    string `json:"shelf_id"`
+	Code       string `json:"code"`
+	ProductID  string `json:"product_id" binding:"required"`
+	CountedQty *int   `json:"counted_qty" binding:"required"`
+}
+
+type GetCycleCountsQuery struct {
+	Page      int
+	Size      int
+	Status    string
+	StorageID string
+}
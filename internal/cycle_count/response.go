@@ -0,0 +1,40 @@
+package cyclecount
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// VarianceLine compares a counted quantity with the placement's current
+// quantity. Placements nobody counted yet have no CountedQty or Variance.
+type VarianceLine struct {
+	ShelfID     primitive.ObjectID `json:"shelf_id"`
+	ShelfPath   string             `json:"shelf_path"`
+	ProductID   primitive.ObjectID `json:"product_id"`
+	ExpectedQty int                `json:"expected_qty"`
+	CountedQty  *int               `json:"counted_qty"`
+	Variance    *int               `json:"variance"`
+	CountedBy   string             `json:"counted_by,omitempty"`
+	CountedAt   *time.Time         `json:"counted_at,omitempty"`
+}
+
+type CycleCountResponse struct {
+	*CycleCount
+	Counted   int             `json:"counted"`
+	Uncounted int             `json:"uncounted"`
+	Lines     []*VarianceLine `json:"lines"`
+}
+
+type CycleCountListResponse struct {
+	Items      []*CycleCount `json:"items"`
+	Total      int64         `json:"total"`
+	Page       int           `json:"page"`
+	Size       int           `json:"size"`
+	TotalPages int           `json:"total_pages"`
+}
+
+type ApproveCycleCountResponse struct {
+	DocumentNumber string `json:"document_number"`
+	Adjustments    int    `json:"adjustments"`
+}
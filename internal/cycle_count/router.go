@@ -0,0 +1,23 @@
+package cyclecount
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *CycleCountHandler) {
+
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/cycle_count").Use(middleware.Secured())
+		{
+			location.POST("", handler.CreateCycleCount)
+			location.GET("", handler.GetCycleCounts)
+			location.GET("/:id", handler.GetCycleCountByID)
+			location.POST("/:id/counts", handler.RecordCount)
+			location.POST("/:id/approve", handler.ApproveCycleCount)
+			location.POST("/:id/cancel", handler.CancelCycleCount)
+		}
+	}
+}
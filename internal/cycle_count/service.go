@@ -0,0 +1,648 @@
+package cyclecount
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inventory-service/internal/product"
+	productplacement "inventory-service/internal/product_placement"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/reservation"
+	"inventory-service/internal/shared/model"
+	shelfquantity "inventory-service/internal/shelf_quantity"
+	"inventory-service/internal/storage"
+	"inventory-service/pkg/constants"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+)
+
+var (
+	// ErrCountClosed reports a count that was already approved or cancelled.
+	ErrCountClosed = errors.New("cycle count is not open")
+	// ErrShelfAlreadyFrozen reports a shelf locked by another open count.
+	ErrShelfAlreadyFrozen = errors.New("shelf is already frozen by another cycle count")
+	// ErrStockChanged reports a placement that no longer holds the quantity
+	// read at the start of the approval.
+	ErrStockChanged = errors.New("stock changed during approval, retry")
+	// ErrSerializedVariance reports a count that differs from the stock of a
+	// serialized product, which must be corrected by serial number instead.
+	ErrSerializedVariance = errors.New("serialized product has a variance, move it by serial number")
+)
+
+type CycleCountService interface {
+	CreateCycleCount(ctx context.Context, req *CreateCycleCountRequest, userID string) (*CycleCount, error)
+	GetCycleCounts(ctx context.Context, query *GetCycleCountsQuery) (*CycleCountListResponse, error)
+	GetCycleCountByID(ctx context.Context, id string) (*CycleCountResponse, error)
+	RecordCount(ctx context.Context, id string, req *RecordCountRequest, userID string) (*CountLine, error)
+	ApproveCycleCount(ctx context.Context, id string, userID string) (*ApproveCycleCountResponse, error)
+	CancelCycleCount(ctx context.Context, id string, userID string) error
+}
+
+type cycleCountService struct {
+	repository              CycleCountRepository
+	storageRepository       storage.StorageRepository
+	placementRepository     productplacement.ProductPlacementRepository
+	transactionRepository   producttransaction.ProductTransactionRepository
+	reservationService      reservation.ReservationService
+	shelfQuantityRepository shelfquantity.ShelfQuantityRepository
+	productService          product.ProductService
+	typeRules               *storage.TypeRules
+	mongoClient             *mongo.Client
+}
+
+func NewCycleCountService(
+	repository CycleCountRepository,
+	storageRepository storage.StorageRepository,
+	placementRepository productplacement.ProductPlacementRepository,
+	transactionRepository producttransaction.ProductTransactionRepository,
+	reservationService reservation.ReservationService,
+	shelfQuantityRepository shelfquantity.ShelfQuantityRepository,
+	productService product.ProductService,
+	typeRules *storage.TypeRules,
+	mongoClient *mongo.Client,
+) CycleCountService {
+	return &cycleCountService{
+		repository:              repository,
+		storageRepository:       storageRepository,
+		placementRepository:     placementRepository,
+		transactionRepository:   transactionRepository,
+		reservationService:      reservationService,
+		shelfQuantityRepository: shelfQuantityRepository,
+		productService:          productService,
+		typeRules:               typeRules,
+		mongoClient:             mongoClient,
+	}
+}
+
+func (s *cycleCountService) CreateCycleCount(ctx context.Context, req *CreateCycleCountRequest, userID string) (*CycleCount, error) {
+
+	storageID, err := primitive.ObjectIDFromHex(req.StorageID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage id: %w", err)
+	}
+
+	root, err := s.storageRepository.GetStorageByID(ctx, &storageID)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
+
+	descendants, err := s.storageRepository.GetTreeNodes(ctx, &storageID, -1)
+	if err != nil {
+		return nil, err
+	}
+
+	storagies := []*model.Storage{root}
+	for _, descendant := range descendants {
+		storagies = append(storagies, (*model.Storage)(descendant))
+	}
+
+	var shelfIDs []primitive.ObjectID
+	for _, storage := range storagies {
+		if !s.typeRules.CanHoldPlacements(storage.Type) {
+			continue
+		}
+		if req.Freeze && storage.FrozenBy != nil {
+			return nil, fmt.Errorf("%w: %s", ErrShelfAlreadyFrozen, storage.Path)
+		}
+		shelfIDs = append(shelfIDs, storage.ID)
+	}
+
+	if len(shelfIDs) == 0 {
+		return nil, fmt.Errorf("storage has no shelves to count")
+	}
+
+	now := time.Now()
+	count := &CycleCount{
+		ID:        primitive.NewObjectID(),
+		StorageID: root.ID,
+		Path:      root.Path,
+		ShelfIDs:  shelfIDs,
+		Freeze:    req.Freeze,
+		Status:    StatusOpen,
+		Note:      req.Note,
+		CreatedBy: userID,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		if err := s.repository.CreateCycleCount(sc, count); err != nil {
+			return nil, err
+		}
+		if !count.Freeze {
+			return nil, nil
+		}
+		frozen, err := s.storageRepository.FreezeStorages(sc, shelfIDs, count.ID)
+		if err != nil {
+			return nil, err
+		}
+		if !frozen {
+			return nil, ErrShelfAlreadyFrozen
+		}
+		return nil, nil
+	}
+
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
+		return nil, err
+	}
+
+	return count, nil
+}
+
+func (s *cycleCountService) GetCycleCounts(ctx context.Context, query *GetCycleCountsQuery) (*CycleCountListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	filter := &CycleCountFilter{
+		Status: query.Status,
+		Skip:   int64((page - 1) * size),
+		Limit:  int64(size),
+	}
+
+	if query.StorageID != "" {
+		storageID, err := primitive.ObjectIDFromHex(query.StorageID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage id: %w", err)
+		}
+		filter.StorageID = &storageID
+	}
+
+	counts, total, err := s.repository.GetCycleCounts(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CycleCountListResponse{
+		Items:      counts,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
+
+func (s *cycleCountService) getCycleCount(ctx context.Context, id string) (*CycleCount, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	count, err := s.repository.GetCycleCountByID(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if count == nil {
+		return nil, fmt.Errorf("cycle count not found")
+	}
+
+	return count, nil
+}
+
+// GetCycleCountByID returns the count with one line per placement in its
+// shelves and per counted product, comparing counts with current stock.
+func (s *cycleCountService) GetCycleCountByID(ctx context.Context, id string) (*CycleCountResponse, error) {
+
+	count, err := s.getCycleCount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := s.repository.GetLines(ctx, count.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	quantities, err := s.placementRepository.GetQuantitiesByShelfIDs(ctx, count.ShelfIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	shelves, err := s.storageRepository.GetStoragesByIDs(ctx, count.ShelfIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[primitive.ObjectID]string, len(shelves))
+	for _, shelf := range shelves {
+		paths[shelf.ID] = shelf.Path
+	}
+
+	type key struct{ shelfID, productID primitive.ObjectID }
+	byKey := make(map[key]*VarianceLine)
+
+	variance := func(shelfID, productID primitive.ObjectID) *VarianceLine {
+		k := key{shelfID, productID}
+		if line, ok := byKey[k]; ok {
+			return line
+		}
+		line := &VarianceLine{
+			ShelfID:     shelfID,
+			ShelfPath:   paths[shelfID],
+			ProductID:   productID,
+			ExpectedQty: quantities[shelfID][productID],
+		}
+		byKey[k] = line
+		return line
+	}
+
+	for shelfID, products := range quantities {
+		for productID := range products {
+			variance(shelfID, productID)
+		}
+	}
+
+	response := &CycleCountResponse{CycleCount: count, Lines: []*VarianceLine{}}
+
+	for _, line := range lines {
+		result := variance(line.ShelfID, line.ProductID)
+		counted := line.CountedQty
+		difference := counted - result.ExpectedQty
+		countedAt := line.CountedAt
+		result.CountedQty = &counted
+		result.Variance = &difference
+		result.CountedBy = line.CountedBy
+		result.CountedAt = &countedAt
+	}
+
+	for _, line := range byKey {
+		if line.CountedQty == nil {
+			response.Uncounted++
+		} else {
+			response.Counted++
+		}
+		response.Lines = append(response.Lines, line)
+	}
+
+	sort.Slice(response.Lines, func(i, j int) bool {
+		a, b := response.Lines[i], response.Lines[j]
+		if a.ShelfPath != b.ShelfPath {
+			return a.ShelfPath < b.ShelfPath
+		}
+		return a.ProductID.Hex() < b.ProductID.Hex()
+	})
+
+	return response, nil
+}
+
+func (s *cycleCountService) RecordCount(ctx context.Context, id string, req *RecordCountRequest, userID string) (*CountLine, error) {
+
+	count, err := s.getCycleCount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if count.Status != StatusOpen {
+		return nil, ErrCountClosed
+	}
+
+	if req.CountedQty == nil {
+		return nil, fmt.Errorf("counted_qty is required")
+	}
+	if *req.CountedQty < 0 {
+		return nil, fmt.Errorf("counted_qty cannot be negative")
+	}
+
+	productID, err := primitive.ObjectIDFromHex(req.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid product id: %w", err)
+	}
+
+	shelfID, err := s.resolveShelf(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	inCount := false
+	for _, id := range count.ShelfIDs {
+		if id == shelfID {
+			inCount = true
+			break
+		}
+	}
+	if !inCount {
+		return nil, fmt.Errorf("shelf is not part of this cycle count")
+	}
+
+	line := &CountLine{
+		ID:         primitive.NewObjectID(),
+		CountID:    count.ID,
+		ShelfID:    shelfID,
+		ProductID:  productID,
+		CountedQty: *req.CountedQty,
+		CountedBy:  userID,
+		CountedAt:  time.Now(),
+	}
+
+	if err := s.repository.UpsertLine(ctx, line); err != nil {
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// resolveShelf accepts either a shelf id or a scanned storage or inventory
+// QR code.
+func (s *cycleCountService) resolveShelf(ctx context.Context, req *RecordCountRequest) (primitive.ObjectID, error) {
+
+	code := strings.TrimSpace(req.Code)
+
+	switch {
+	case req.ShelfID != "":
+		shelfID, err := primitive.ObjectIDFromHex(req.ShelfID)
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("invalid shelf id: %w", err)
+		}
+		return shelfID, nil
+	case strings.HasPrefix(code, constants.StorageQRPrefix):
+		shelfID, err := primitive.ObjectIDFromHex(strings.TrimPrefix(code, constants.StorageQRPrefix))
+		if err != nil {
+			return primitive.NilObjectID, fmt.Errorf("invalid storage code: %w", err)
+		}
+		return shelfID, nil
+	case strings.HasPrefix(code, constants.InventoryQRPrefix):
+		shelfQuantity, err := s.shelfQuantityRepository.GetShelfQuantityByCode(ctx, strings.TrimPrefix(code, constants.InventoryQRPrefix))
+		if err != nil {
+			return primitive.NilObjectID, err
+		}
+		if shelfQuantity == nil {
+			return primitive.NilObjectID, fmt.Errorf("inventory code not found")
+		}
+		return shelfQuantity.ShelfID, nil
+	case code != "":
+		return primitive.NilObjectID, fmt.Errorf("unrecognized code %q", code)
+	default:
+		return primitive.NilObjectID, fmt.Errorf("shelf_id or code is required")
+	}
+}
+
+// ApproveCycleCount sets every counted placement to its counted quantity,
+// posting ADJUST entries for the differences under a shared document number,
+// then recomputes the remaining capacity of the affected shelves. Surplus is
+// booked without a lot, shortages are taken from lots first-expiring-first-out
+// and, like an OUT, cannot take reserved stock.
+// Products nobody counted keep their stock. A variance on a product that
+// product-service marks as serialized blocks the approval, since it cannot be
+// booked without serial numbers.
+func (s *cycleCountService) ApproveCycleCount(ctx context.Context, id string, userID string) (*ApproveCycleCountResponse, error) {
+
+	count, err := s.getCycleCount(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := s.repository.GetLines(ctx, count.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The shelves are frozen while counted, so the variances read here are
+	// the ones approved below and only their products are looked up.
+	counted, err := s.placementRepository.GetQuantitiesByShelfIDs(ctx, count.ShelfIDs)
+	if err != nil {
+		return nil, err
+	}
+	var varied []primitive.ObjectID
+	for _, line := range lines {
+		if line.CountedQty != counted[line.ShelfID][line.ProductID] {
+			varied = append(varied, line.ProductID)
+		}
+	}
+	serialized, err := product.SerializedProducts(ctx, s.productService, varied)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ApproveCycleCountResponse{DocumentNumber: "COUNT-" + strings.ToUpper(count.ID.Hex())}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		now := time.Now()
+		response.Adjustments = 0
+
+		closed, err := s.repository.CloseCycleCount(sc, count.ID, StatusApproved, userID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !closed {
+			return nil, ErrCountClosed
+		}
+
+		reserved, err := s.transactionRepository.ReserveDocumentNumber(sc, response.DocumentNumber, userID, now)
+		if err != nil {
+			return nil, err
+		}
+		if !reserved {
+			return nil, producttransaction.ErrDocumentNumberUsed
+		}
+
+		quantities, err := s.placementRepository.GetQuantitiesByShelfIDs(sc, count.ShelfIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		// Surpluses are booked before shortages, so stock that only moved
+		// between counted shelves is on hand when the shortages are checked
+		// against reservations.
+		ordered := append([]*CountLine(nil), lines...)
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return ordered[i].CountedQty > quantities[ordered[i].ShelfID][ordered[i].ProductID] &&
+				ordered[j].CountedQty <= quantities[ordered[j].ShelfID][ordered[j].ProductID]
+		})
+
+		shelves, err := s.storageRepository.GetStoragesByIDs(sc, count.ShelfIDs)
+		if err != nil {
+			return nil, err
+		}
+
+		byID := make(map[primitive.ObjectID]*model.Storage, len(shelves))
+		for _, shelf := range shelves {
+			byID[shelf.ID] = shelf
+		}
+
+		adjusted := make(map[primitive.ObjectID]bool)
+
+		for _, line := range ordered {
+			shelf := byID[line.ShelfID]
+			if shelf == nil {
+				return nil, fmt.Errorf("shelf %s no longer exists", line.ShelfID.Hex())
+			}
+
+			delta := line.CountedQty - quantities[line.ShelfID][line.ProductID]
+			if delta == 0 {
+				continue
+			}
+			isSerialized, checked := serialized[line.ProductID]
+			if !checked {
+				return nil, ErrStockChanged
+			}
+			if isSerialized {
+				return nil, fmt.Errorf("%w: product %s on %s", ErrSerializedVariance, line.ProductID.Hex(), shelf.Path)
+			}
+
+			adjustments := []*productplacement.LotAllocation{{Quantity: delta}}
+			if delta > 0 {
+				err = s.placementRepository.AddQuantity(sc, &productplacement.ProductPlacement{
+					ID:          primitive.NewObjectID(),
+					ProductID:   line.ProductID,
+					ShelfID:     line.ShelfID,
+					CurrentQty:  delta,
+					Path:        shelf.Path,
+					AncestorIDs: shelf.AncestorIDs,
+					CreatedAt:   now,
+					UpdatedAt:   now,
+				})
+				if err != nil {
+					return nil, err
+				}
+			} else {
+				// A shortage is a removal like any OUT, so it cannot take
+				// stock that is reserved.
+				if err := s.reservationService.CheckRemoval(sc, line.ProductID, line.ShelfID, -delta, nil); err != nil {
+					return nil, err
+				}
+
+				// Missing stock is taken from the lots that expire first.
+				lots, err := s.placementRepository.GetStockedLots(sc, line.ProductID, line.ShelfID)
+				if err != nil {
+					return nil, err
+				}
+				allocations, ok := productplacement.AllocateFEFO(lots, -delta)
+				if !ok {
+					return nil, ErrStockChanged
+				}
+				adjustments = adjustments[:0]
+				for _, allocation := range allocations {
+					removed, err := s.placementRepository.RemoveQuantity(sc, line.ProductID, line.ShelfID, allocation.LotNumber, allocation.Quantity)
+					if err != nil {
+						return nil, err
+					}
+					if !removed {
+						return nil, ErrStockChanged
+					}
+					allocation.Quantity = -allocation.Quantity
+					adjustments = append(adjustments, allocation)
+				}
+			}
+
+			if quantities[line.ShelfID] == nil {
+				quantities[line.ShelfID] = make(map[primitive.ObjectID]int)
+			}
+			quantities[line.ShelfID][line.ProductID] = line.CountedQty
+			adjusted[line.ShelfID] = true
+
+			for _, adjustment := range adjustments {
+				response.Adjustments++
+				err = s.transactionRepository.CreateProductTransaction(sc, &producttransaction.ProductTransaction{
+					ID:             primitive.NewObjectID(),
+					ProductID:      line.ProductID,
+					ShelfID:        line.ShelfID,
+					Quantity:       adjustment.Quantity,
+					Action:         producttransaction.ActionAdjust,
+					LotNumber:      adjustment.LotNumber,
+					ExpiryDate:     adjustment.ExpiryDate,
+					DocumentNumber: response.DocumentNumber,
+					ActionBy:       userID,
+					ActionAt:       now,
+					CreatedAt:      now,
+					UpdatedAt:      now,
+				})
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		for shelfID := range adjusted {
+			shelf := byID[shelfID]
+			if shelf.Slots == nil || shelf.Levels == nil {
+				continue
+			}
+			stocked := 0
+			for _, quantity := range quantities[shelfID] {
+				stocked += quantity
+			}
+			// A count above capacity leaves a negative remainder, which
+			// blocks further INs until stock is taken out.
+			if err := s.storageRepository.SetTotalStock(sc, shelfID, (*shelf.Slots)*(*shelf.Levels)-stocked); err != nil {
+				return nil, err
+			}
+		}
+
+		if count.Freeze {
+			return nil, s.storageRepository.UnfreezeStorages(sc, count.ShelfIDs, count.ID)
+		}
+
+		return nil, nil
+	}
+
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s *cycleCountService) CancelCycleCount(ctx context.Context, id string, userID string) error {
+
+	count, err := s.getCycleCount(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		closed, err := s.repository.CloseCycleCount(sc, count.ID, StatusCancelled, userID, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		if !closed {
+			return nil, ErrCountClosed
+		}
+		if count.Freeze {
+			return nil, s.storageRepository.UnfreezeStorages(sc, count.ShelfIDs, count.ID)
+		}
+		return nil, nil
+	}
+
+	_, err = session.WithTransaction(ctx, callback)
+	return err
+}
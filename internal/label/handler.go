@@ -0,0 +1,58 @@
+package label
+
+import (
+	"fmt"
+	"inventory-service/helper"
+
+	"github.com/gin-gonic/gin"
+)
+
+type LabelHandler struct {
+	LabelService LabelService
+}
+
+func NewLabelHandler(labelService LabelService) *LabelHandler {
+	return &LabelHandler{
+		LabelService: labelService,
+	}
+}
+
+func (h *LabelHandler) GetStorageLabel(c *gin.Context) {
+
+	id := c.Param("id")
+	format := c.DefaultQuery("format", FormatPNG)
+	size := c.Query("size")
+
+	file, err := h.LabelService.GetStorageLabel(c, id, format, size)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	sendFile(c, file)
+
+}
+
+func (h *LabelHandler) GetLabels(c *gin.Context) {
+
+	var req BulkLabelRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	file, err := h.LabelService.GetLabels(c, &req)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	sendFile(c, file)
+
+}
+
+func sendFile(c *gin.Context, file *LabelFile) {
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, file.FileName))
+	c.Data(200, file.ContentType, file.Data)
+}
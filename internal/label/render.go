@@ -0,0 +1,358 @@
+package label
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/jung-kurt/gofpdf"
+	qrcode "github.com/skip2/go-qrcode"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	pixelsPerMM   = 12 // ~300 dpi
+	labelMarginMM = 2.0
+	sheetMarginMM = 10.0
+	sheetGapMM    = 2.0
+	fontName      = "goregular"
+)
+
+type Label struct {
+	Title    string
+	Subtitle string
+	Caption  string
+	Payload  string
+}
+
+type LabelSize struct {
+	Name     string
+	WidthMM  float64
+	HeightMM float64
+}
+
+var labelSizes = map[string]LabelSize{
+	"small":  {Name: "small", WidthMM: 40, HeightMM: 30},
+	"medium": {Name: "medium", WidthMM: 60, HeightMM: 40},
+	"large":  {Name: "large", WidthMM: 100, HeightMM: 70},
+}
+
+func parseSize(name string) (LabelSize, error) {
+
+	if name == "" {
+		return labelSizes["medium"], nil
+	}
+
+	size, ok := labelSizes[name]
+	if !ok {
+		return LabelSize{}, fmt.Errorf("unknown label size %q, use small, medium or large", name)
+	}
+
+	return size, nil
+}
+
+// layout splits a label into a square QR area on the left and three text
+// lines on the right, all in millimetres.
+type layout struct {
+	qrX, qrY, qrSide float64
+	textX, textWidth float64
+	lineY            [3]float64
+	lineSize         [3]float64
+}
+
+func newLayout(size LabelSize) layout {
+
+	qrSide := math.Min(size.HeightMM-2*labelMarginMM, size.WidthMM*0.45)
+	qrY := (size.HeightMM - qrSide) / 2
+	textX := labelMarginMM + qrSide + labelMarginMM
+	titleSize := size.HeightMM / 9
+	smallSize := size.HeightMM / 14
+
+	return layout{
+		qrX:       labelMarginMM,
+		qrY:       qrY,
+		qrSide:    qrSide,
+		textX:     textX,
+		textWidth: size.WidthMM - textX - labelMarginMM,
+		lineY:     [3]float64{qrY + titleSize, qrY + titleSize + smallSize*1.8, qrY + qrSide},
+		lineSize:  [3]float64{titleSize, smallSize, smallSize},
+	}
+}
+
+func (l Label) lines() [3]string {
+	return [3]string{l.Title, l.Subtitle, l.Caption}
+}
+
+func qrModules(payload string) ([][]bool, error) {
+
+	qr, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return nil, err
+	}
+	qr.DisableBorder = true
+
+	return qr.Bitmap(), nil
+}
+
+var (
+	fontOnce   sync.Once
+	parsedFont *opentype.Font
+	fontErr    error
+)
+
+func loadFont() (*opentype.Font, error) {
+	fontOnce.Do(func() {
+		parsedFont, fontErr = opentype.Parse(goregular.TTF)
+	})
+	return parsedFont, fontErr
+}
+
+// fallbackText replaces characters the bundled font has no glyph for with
+// their base letters (e.g. Vietnamese "ệ" becomes "e") instead of boxes.
+func fallbackText(face font.Face, text string) string {
+
+	var b strings.Builder
+	for _, r := range text {
+		if _, ok := face.GlyphAdvance(r); ok {
+			b.WriteRune(r)
+			continue
+		}
+		for _, part := range norm.NFD.String(string(r)) {
+			if _, ok := face.GlyphAdvance(part); ok {
+				b.WriteRune(part)
+				break
+			}
+		}
+	}
+
+	return b.String()
+}
+
+func renderPNG(label Label, size LabelSize) ([]byte, error) {
+
+	modules, err := qrModules(label.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	ttf, err := loadFont()
+	if err != nil {
+		return nil, err
+	}
+
+	px := func(mm float64) int { return int(math.Round(mm * pixelsPerMM)) }
+
+	lay := newLayout(size)
+	img := image.NewRGBA(image.Rect(0, 0, px(size.WidthMM), px(size.HeightMM)))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	moduleSize := px(lay.qrSide) / len(modules)
+	offsetX := px(lay.qrX) + (px(lay.qrSide)-moduleSize*len(modules))/2
+	offsetY := px(lay.qrY) + (px(lay.qrSide)-moduleSize*len(modules))/2
+	for y, row := range modules {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			rect := image.Rect(offsetX+x*moduleSize, offsetY+y*moduleSize, offsetX+(x+1)*moduleSize, offsetY+(y+1)*moduleSize)
+			draw.Draw(img, rect, image.Black, image.Point{}, draw.Src)
+		}
+	}
+
+	for i, text := range label.lines() {
+		if text == "" {
+			continue
+		}
+
+		face, err := opentype.NewFace(ttf, &opentype.FaceOptions{
+			Size:    lay.lineSize[i] * pixelsPerMM,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		drawer := &font.Drawer{
+			Dst:  img,
+			Src:  image.NewUniform(color.Black),
+			Face: face,
+			Dot:  fixed.P(px(lay.textX), px(lay.lineY[i])),
+		}
+		text = fallbackText(face, text)
+		drawer.DrawString(fitText(text, func(s string) float64 {
+			return float64(drawer.MeasureString(s).Round())
+		}, float64(px(lay.textWidth)), i > 0))
+
+		face.Close()
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func renderSVG(label Label, size LabelSize) ([]byte, error) {
+
+	modules, err := qrModules(label.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	lay := newLayout(size)
+	moduleSize := lay.qrSide / float64(len(modules))
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%gmm" height="%gmm" viewBox="0 0 %g %g">`, size.WidthMM, size.HeightMM, size.WidthMM, size.HeightMM)
+	fmt.Fprintf(&buf, `<rect width="%g" height="%g" fill="#fff"/>`, size.WidthMM, size.HeightMM)
+
+	buf.WriteString(`<path fill="#000" d="`)
+	for y, row := range modules {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&buf, "M%.3f %.3fh%.3fv%.3fh-%.3fz", lay.qrX+float64(x)*moduleSize, lay.qrY+float64(y)*moduleSize, moduleSize, moduleSize, moduleSize)
+			}
+		}
+	}
+	buf.WriteString(`"/>`)
+
+	for i, text := range label.lines() {
+		if text == "" {
+			continue
+		}
+		// Approximate glyph width as 0.55em since SVG has no layout engine here.
+		fontSize := lay.lineSize[i]
+		text = fitText(text, func(s string) float64 {
+			return float64(len([]rune(s))) * fontSize * 0.55
+		}, lay.textWidth, i > 0)
+
+		fmt.Fprintf(&buf, `<text x="%g" y="%g" font-family="sans-serif" font-size="%g">`, lay.textX, lay.lineY[i], fontSize)
+		if err := xml.EscapeText(&buf, []byte(text)); err != nil {
+			return nil, err
+		}
+		buf.WriteString(`</text>`)
+	}
+
+	buf.WriteString(`</svg>`)
+
+	return buf.Bytes(), nil
+}
+
+// renderPDF lays the labels out on as many A4 sheets as needed.
+func renderPDF(labels []Label, size LabelSize) ([]byte, error) {
+
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	pdf.SetMargins(sheetMarginMM, sheetMarginMM, sheetMarginMM)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddUTF8FontFromBytes(fontName, "", goregular.TTF)
+
+	pageWidth, pageHeight := pdf.GetPageSize()
+	cols := int((pageWidth - 2*sheetMarginMM + sheetGapMM) / (size.WidthMM + sheetGapMM))
+	rows := int((pageHeight - 2*sheetMarginMM + sheetGapMM) / (size.HeightMM + sheetGapMM))
+	if cols < 1 || rows < 1 {
+		return nil, fmt.Errorf("label size %q does not fit on a sheet", size.Name)
+	}
+
+	ttf, err := loadFont()
+	if err != nil {
+		return nil, err
+	}
+
+	face, err := opentype.NewFace(ttf, &opentype.FaceOptions{Size: 12, DPI: 72})
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+
+	lay := newLayout(size)
+	perPage := cols * rows
+
+	for i, label := range labels {
+		if i%perPage == 0 {
+			pdf.AddPage()
+		}
+
+		slot := i % perPage
+		originX := sheetMarginMM + float64(slot%cols)*(size.WidthMM+sheetGapMM)
+		originY := sheetMarginMM + float64(slot/cols)*(size.HeightMM+sheetGapMM)
+
+		pdf.SetDrawColor(200, 200, 200)
+		pdf.Rect(originX, originY, size.WidthMM, size.HeightMM, "D")
+
+		modules, err := qrModules(label.Payload)
+		if err != nil {
+			return nil, err
+		}
+
+		moduleSize := lay.qrSide / float64(len(modules))
+		pdf.SetFillColor(0, 0, 0)
+		for y, row := range modules {
+			for x, dark := range row {
+				if dark {
+					pdf.Rect(originX+lay.qrX+float64(x)*moduleSize, originY+lay.qrY+float64(y)*moduleSize, moduleSize, moduleSize, "F")
+				}
+			}
+		}
+
+		for j, text := range label.lines() {
+			if text == "" {
+				continue
+			}
+			// Font sizes are in points, the layout is in millimetres.
+			pdf.SetFont(fontName, "", lay.lineSize[j]*72/25.4)
+			text = fitText(fallbackText(face, text), pdf.GetStringWidth, lay.textWidth, j > 0)
+			pdf.Text(originX+lay.textX, originY+lay.lineY[j], text)
+		}
+	}
+
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fitText shortens text with an ellipsis until it fits maxWidth. Paths and
+// codes keep their tail since that is their most specific part.
+func fitText(text string, measure func(string) float64, maxWidth float64, keepTail bool) string {
+
+	if measure(text) <= maxWidth {
+		return text
+	}
+
+	runes := []rune(text)
+	for len(runes) > 0 {
+		var candidate string
+		if keepTail {
+			runes = runes[1:]
+			candidate = "…" + strings.TrimSpace(string(runes))
+		} else {
+			runes = runes[:len(runes)-1]
+			candidate = strings.TrimSpace(string(runes)) + "…"
+		}
+		if measure(candidate) <= maxWidth {
+			return candidate
+		}
+	}
+
+	return ""
+}
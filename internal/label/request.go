@@ -0,0 +1,9 @@
+package label
+
+type BulkLabelRequest struct {
+	StorageID          string   `json:"storage_id"`
+	IncludeSubtree     bool     `json:"include_subtree"`
+	ShelfQuantityCodes []string `json:"shelf_quantity_codes"`
+	Format             string   `json:"format"`
+	Size               string   `json:"size"`
+}
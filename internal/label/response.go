@@ -0,0 +1,7 @@
+package label
+
+type LabelFile struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
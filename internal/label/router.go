@@ -0,0 +1,19 @@
+package label
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *LabelHandler) {
+	api := r.Group("api/v1")
+	{
+		api.GET("/storage/:id/label", middleware.Secured(), handler.GetStorageLabel)
+
+		location := api.Group("/labels").Use(middleware.Secured())
+		{
+			location.POST("", handler.GetLabels)
+		}
+	}
+}
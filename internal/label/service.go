@@ -0,0 +1,229 @@
+package label
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"inventory-service/internal/shared/model"
+	shelfquantity "inventory-service/internal/shelf_quantity"
+	"inventory-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+	FormatPDF = "pdf"
+
+	maxLabels = 1000
+)
+
+type LabelService interface {
+	GetStorageLabel(ctx context.Context, id, format, size string) (*LabelFile, error)
+	GetLabels(ctx context.Context, req *BulkLabelRequest) (*LabelFile, error)
+}
+
+type labelService struct {
+	storageRepository       storage.StorageRepository
+	shelfQuantityRepository shelfquantity.ShelfQuantityRepository
+}
+
+func NewLabelService(storageRepository storage.StorageRepository, shelfQuantityRepository shelfquantity.ShelfQuantityRepository) LabelService {
+	return &labelService{
+		storageRepository:       storageRepository,
+		shelfQuantityRepository: shelfQuantityRepository,
+	}
+}
+
+func (s *labelService) GetStorageLabel(ctx context.Context, id, format, size string) (*LabelFile, error) {
+
+	labelSize, err := parseSize(size)
+	if err != nil {
+		return nil, err
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	storage, err := s.storageRepository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
+
+	return render([]Label{storageLabel(storage)}, format, labelSize, "storage-"+storage.ID.Hex())
+}
+
+func (s *labelService) GetLabels(ctx context.Context, req *BulkLabelRequest) (*LabelFile, error) {
+
+	labelSize, err := parseSize(req.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	format := req.Format
+	if format == "" {
+		format = FormatPDF
+	}
+
+	var labels []Label
+
+	if req.StorageID != "" {
+		storageLabels, err := s.storageLabels(ctx, req.StorageID, req.IncludeSubtree)
+		if err != nil {
+			return nil, err
+		}
+		labels = append(labels, storageLabels...)
+	}
+
+	for _, code := range req.ShelfQuantityCodes {
+		shelfQuantity, err := s.shelfQuantityRepository.GetShelfQuantityByCode(ctx, code)
+		if err != nil {
+			return nil, err
+		}
+		if shelfQuantity == nil {
+			return nil, fmt.Errorf("inventory code %q not found", code)
+		}
+
+		shelf, err := s.storageRepository.GetStorageByID(ctx, &shelfQuantity.ShelfID)
+		if err != nil {
+			return nil, err
+		}
+
+		labels = append(labels, shelfQuantityLabel(shelfQuantity, shelf))
+	}
+
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("storage_id or shelf_quantity_codes is required")
+	}
+
+	if len(labels) > maxLabels {
+		return nil, fmt.Errorf("too many labels, at most %d per request", maxLabels)
+	}
+
+	return render(labels, format, labelSize, "labels")
+}
+
+func (s *labelService) storageLabels(ctx context.Context, id string, includeSubtree bool) ([]Label, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage id: %w", err)
+	}
+
+	root, err := s.storageRepository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
+
+	labels := []Label{storageLabel(root)}
+
+	if includeSubtree {
+		descendants, err := s.storageRepository.GetTreeNodes(ctx, &objectID, -1)
+		if err != nil {
+			return nil, err
+		}
+		for _, descendant := range descendants {
+			storage := model.Storage(*descendant)
+			labels = append(labels, storageLabel(&storage))
+		}
+	}
+
+	return labels, nil
+}
+
+func storageLabel(storage *model.Storage) Label {
+
+	caption := storage.ID.Hex()
+	if storage.ShelfID != nil && *storage.ShelfID != "" {
+		caption = *storage.ShelfID
+	}
+
+	return Label{
+		Title:    storage.Name,
+		Subtitle: storage.Path,
+		Caption:  caption,
+		Payload:  storage.QRCode,
+	}
+}
+
+func shelfQuantityLabel(shelfQuantity *shelfquantity.ShelfQuantity, shelf *model.Storage) Label {
+
+	label := Label{
+		Title:   shelfQuantity.Code,
+		Caption: shelfQuantity.Note,
+		Payload: shelfQuantity.QRCode,
+	}
+
+	if shelf != nil {
+		label.Subtitle = shelf.Path
+	}
+
+	return label
+}
+
+// render produces a single image for one png/svg label, a zip of images for
+// several, or one PDF sheet for any number of labels.
+func render(labels []Label, format string, size LabelSize, name string) (*LabelFile, error) {
+
+	switch format {
+	case FormatPDF:
+		data, err := renderPDF(labels, size)
+		if err != nil {
+			return nil, err
+		}
+		return &LabelFile{FileName: name + ".pdf", ContentType: "application/pdf", Data: data}, nil
+	case FormatPNG, FormatSVG:
+	default:
+		return nil, fmt.Errorf("unknown label format %q, use png, svg or pdf", format)
+	}
+
+	renderImage := renderPNG
+	contentType := "image/png"
+	if format == FormatSVG {
+		renderImage = renderSVG
+		contentType = "image/svg+xml"
+	}
+
+	if len(labels) == 1 {
+		data, err := renderImage(labels[0], size)
+		if err != nil {
+			return nil, err
+		}
+		return &LabelFile{FileName: name + "." + format, ContentType: contentType, Data: data}, nil
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	for i, label := range labels {
+		data, err := renderImage(label, size)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := archive.Create(fmt.Sprintf("%04d.%s", i+1, format))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := entry.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return &LabelFile{FileName: name + ".zip", ContentType: "application/zip", Data: buf.Bytes()}, nil
+}
@@ -9,4 +9,7 @@ type Product struct {
 	Image        string  `json:"image" bson:"image"`
 	FolderName   string  `json:"folder_name" bson:"folder_name"`
 	TopicName    string  `json:"topic_name" bson:"topic_name"`
+	IsActive     bool    `json:"is_active" bson:"is_active"`
+	// IsSerialized products are tracked per unit by serial number.
+	IsSerialized bool `json:"is_serialized" bson:"is_serialized"`
 }
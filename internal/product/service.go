@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/hashicorp/consul/api"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type ProductService interface {
@@ -109,6 +110,14 @@ func (s *productService) GetProductByID(ctx context.Context, id string) (*Produc
 	topicName := ""
 	folderName := ""
 
+	// Products without an explicit flag are treated as active.
+	isActive := true
+	if active, ok := innerData["is_active"].(bool); ok {
+		isActive = active
+	}
+
+	isSerialized, _ := innerData["is_serialized"].(bool)
+
 	if topicData != nil {
 		topicName, _ = topicData["name"].(string)
 	}
@@ -126,6 +135,8 @@ func (s *productService) GetProductByID(ctx context.Context, id string) (*Produc
 		Image:        image,
 		FolderName:   folderName,
 		TopicName:    topicName,
+		IsActive:     isActive,
+		IsSerialized: isSerialized,
 	}, nil
 
 }
@@ -157,3 +168,24 @@ func (c *callAPI) getProductByID(id string, token string) (map[string]interface{
 
 	return myMap, nil
 }
+
+// SerializedProducts asks product-service once per distinct product whether
+// it is tracked by serial number. Products it no longer knows are reported as
+// not serialized, so their leftover stock can still be corrected.
+func SerializedProducts(ctx context.Context, service ProductService, productIDs []primitive.ObjectID) (map[primitive.ObjectID]bool, error) {
+
+	serialized := make(map[primitive.ObjectID]bool, len(productIDs))
+	for _, productID := range productIDs {
+		if _, ok := serialized[productID]; ok {
+			continue
+		}
+
+		product, err := service.GetProductByID(ctx, productID.Hex())
+		if err != nil {
+			return nil, fmt.Errorf("check product %s: %w", productID.Hex(), err)
+		}
+		serialized[productID] = product != nil && product.IsSerialized
+	}
+
+	return serialized, nil
+}
@@ -0,0 +1,38 @@
+package product
+
+import "context"
+
+// stubProductService answers product lookups from memory so the service can
+// run without product-service, e.g. locally or in tests.
+type stubProductService struct {
+	products map[string]*Product
+}
+
+// NewStubProductService returns a ProductService backed by the given products.
+// With no products every id resolves to an active placeholder product.
+func NewStubProductService(products ...*Product) ProductService {
+
+	stub := &stubProductService{}
+	if len(products) > 0 {
+		stub.products = make(map[string]*Product, len(products))
+		for _, product := range products {
+			stub.products[product.ID] = product
+		}
+	}
+
+	return stub
+}
+
+func (s *stubProductService) GetProductByID(ctx context.Context, id string) (*Product, error) {
+
+	if s.products == nil {
+		return &Product{ID: id, Name: "Product " + id, IsActive: true}, nil
+	}
+
+	product, ok := s.products[id]
+	if !ok {
+		return nil, nil
+	}
+
+	return product, nil
+}
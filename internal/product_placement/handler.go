@@ -1,8 +1,11 @@
 package productplacement
 
 import (
+	"fmt"
 	"inventory-service/helper"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -44,3 +47,60 @@ func (h *ProductPlacementHandler) GetProductPlacementsByProductID(c *gin.Context
 	helper.SendSuccess(c, http.StatusOK, "Get product placements by product id successfully", placements)
 
 }
+
+func (h *ProductPlacementHandler) GetExpiringLots(c *gin.Context) {
+
+	days, err := strconv.Atoi(c.DefaultQuery("days", "30"))
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, fmt.Errorf("invalid days: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	query := &GetExpiringLotsQuery{
+		Days:        days,
+		WarehouseID: c.Query("warehouse_id"),
+		ProductID:   c.Query("product_id"),
+	}
+
+	lots, err := h.ProductPlacementService.GetExpiringLots(c, query)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Get expiring lots successfully", lots)
+
+}
+
+func (h *ProductPlacementHandler) GetProductStock(c *gin.Context) {
+
+	productID := c.Param("id")
+
+	stock, err := h.ProductPlacementService.GetProductStock(c, productID)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Get product stock successfully", stock)
+
+}
+
+func (h *ProductPlacementHandler) GetProductsStock(c *gin.Context) {
+
+	var productIDs []string
+	for _, productID := range strings.Split(c.Query("product_ids"), ",") {
+		if productID = strings.TrimSpace(productID); productID != "" {
+			productIDs = append(productIDs, productID)
+		}
+	}
+
+	stocks, err := h.ProductPlacementService.GetProductsStock(c, productIDs)
+	if err != nil {
+		helper.SendError(c, http.StatusBadRequest, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, http.StatusOK, "Get products stock successfully", stocks)
+
+}
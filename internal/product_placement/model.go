@@ -13,6 +13,10 @@ type ProductPlacement struct {
 	CurrentQty  int                  `json:"current_qty" bson:"current_qty"`
 	Path        string               `json:"path" bson:"path"`
 	AncestorIDs []primitive.ObjectID `json:"ancestor_ids" bson:"ancestor_ids"`
-	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
-	UpdatedAt   time.Time            `json:"updated_at" bson:"updated_at"`
+	// LotNumber is empty for stock received without a lot. Placements are
+	// kept per product, shelf and lot.
+	LotNumber  string     `json:"lot_number" bson:"lot_number"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty" bson:"expiry_date,omitempty"`
+	CreatedAt  time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at" bson:"updated_at"`
 }
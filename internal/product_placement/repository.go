@@ -2,19 +2,50 @@ package productplacement
 
 import (
 	"context"
+	"errors"
+	"inventory-service/internal/shared/model"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ProductPlacementRepository interface {
 	CreateProductPlacement(ctx context.Context, productPlacement *ProductPlacement) error
-	GetByProductAndShelf(ctx context.Context, productID, shelfID primitive.ObjectID) (*ProductPlacement, error)
+	GetByProductAndShelf(ctx context.Context, productID, shelfID primitive.ObjectID, lotNumber string) (*ProductPlacement, error)
 	ExistsProductPlacement(ctx context.Context, productID, shelfID primitive.ObjectID) (bool, error)
-	UpdateProductPlacement(ctx context.Context, productID, shelfID primitive.ObjectID, currentQty int) error
+	AddQuantity(ctx context.Context, placement *ProductPlacement) error
+	RemoveQuantity(ctx context.Context, productID, shelfID primitive.ObjectID, lotNumber string, qty int) (bool, error)
+	EnsureIndexes(ctx context.Context) error
+	GetStockedLots(ctx context.Context, productID, shelfID primitive.ObjectID) ([]*ProductPlacement, error)
+	GetLot(ctx context.Context, productID primitive.ObjectID, lotNumber string) (*ProductPlacement, error)
+	GetExpiringPlacements(ctx context.Context, filter *ExpiringFilter) ([]*ProductPlacement, error)
+	SumProductQuantity(ctx context.Context, productID primitive.ObjectID, storageID *primitive.ObjectID) (int, error)
+	GetShelfStocks(ctx context.Context, productIDs []primitive.ObjectID) ([]*ShelfStock, error)
+	ForEachPlacement(ctx context.Context, fn func(*ProductPlacement) error) error
 	GetProductPlacementsByProductID(ctx context.Context, productID primitive.ObjectID) ([]*ProductPlacement, error)
 	GetProductPlacementsByShelfID(ctx context.Context, shelfID primitive.ObjectID) ([]*ProductPlacement, error)
+	UpdatePlacementLocations(ctx context.Context, storages []*model.Storage) error
+	CountStockedPlacements(ctx context.Context, shelfIDs []primitive.ObjectID) (int64, error)
+	DeletePlacementsByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) error
+	SumQuantityByStorageIDs(ctx context.Context, storageIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+	GetQuantitiesByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) (map[primitive.ObjectID]map[primitive.ObjectID]int, error)
+}
+
+type ExpiringFilter struct {
+	Before     time.Time
+	AncestorID *primitive.ObjectID
+	ProductID  *primitive.ObjectID
+}
+
+// ShelfStock is the quantity of a product on a shelf, summed over its lots.
+type ShelfStock struct {
+	ProductID   primitive.ObjectID   `bson:"product_id"`
+	ShelfID     primitive.ObjectID   `bson:"shelf_id"`
+	AncestorIDs []primitive.ObjectID `bson:"ancestor_ids"`
+	Quantity    int                  `bson:"quantity"`
 }
 
 type productPlacementRepository struct {
@@ -37,16 +68,91 @@ func (p *productPlacementRepository) ExistsProductPlacement(ctx context.Context,
 	return count > 0, err
 }
 
-func (p *productPlacementRepository) UpdateProductPlacement(ctx context.Context, productID, shelfID primitive.ObjectID, currentQty int) error {
-	_, err := p.collection.UpdateOne(ctx, bson.M{"product_id": productID, "shelf_id": shelfID}, bson.M{"$set": bson.M{"current_qty": currentQty}})
+// AddQuantity atomically adds placement.CurrentQty to the product's placement
+// for the lot on the shelf, creating the placement from the given fields when
+// missing.
+func (p *productPlacementRepository) AddQuantity(ctx context.Context, placement *ProductPlacement) error {
+
+	insert := bson.M{
+		"_id":          placement.ID,
+		"path":         placement.Path,
+		"ancestor_ids": placement.AncestorIDs,
+		"created_at":   placement.CreatedAt,
+	}
+	if placement.ExpiryDate != nil {
+		insert["expiry_date"] = placement.ExpiryDate
+	}
+
+	filter := bson.M{"product_id": placement.ProductID, "shelf_id": placement.ShelfID, "lot_number": placement.LotNumber}
+	update := bson.M{
+		"$inc":         bson.M{"current_qty": placement.CurrentQty},
+		"$set":         bson.M{"updated_at": placement.UpdatedAt},
+		"$setOnInsert": insert,
+	}
+
+	_, err := p.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// RemoveQuantity atomically takes qty off the product's placement for the
+// lot on the shelf. It reports false when the placement holds less than qty.
+func (p *productPlacementRepository) RemoveQuantity(ctx context.Context, productID, shelfID primitive.ObjectID, lotNumber string, qty int) (bool, error) {
+
+	filter := bson.M{
+		"product_id":  productID,
+		"shelf_id":    shelfID,
+		"lot_number":  lotNumber,
+		"current_qty": bson.M{"$gte": qty},
+	}
+	update := bson.M{
+		"$inc": bson.M{"current_qty": -qty},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := p.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// EnsureIndexes creates the unique product/shelf/lot index that keeps
+// concurrent AddQuantity upserts from creating duplicate placements. Placements
+// written before lots existed get an empty lot number, and the older
+// product/shelf index is dropped since it would reject a second lot.
+func (p *productPlacementRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := p.collection.UpdateMany(ctx, bson.M{"lot_number": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"lot_number": ""}})
+	if err != nil {
+		return err
+	}
+
+	if _, err := p.collection.Indexes().DropOne(ctx, "product_id_1_shelf_id_1"); err != nil {
+		var commandErr mongo.CommandError
+		if !errors.As(err, &commandErr) || (commandErr.Name != "IndexNotFound" && commandErr.Name != "NamespaceNotFound") {
+			return err
+		}
+	}
+
+	_, err = p.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "shelf_id", Value: 1}, {Key: "lot_number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiry_date", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+	})
 	return err
 }
 
-func (p *productPlacementRepository) GetByProductAndShelf(ctx context.Context, productID, shelfID primitive.ObjectID) (*ProductPlacement, error) {
+func (p *productPlacementRepository) GetByProductAndShelf(ctx context.Context, productID, shelfID primitive.ObjectID, lotNumber string) (*ProductPlacement, error) {
 
 	var placement ProductPlacement
 
-	err := p.collection.FindOne(ctx, bson.M{"product_id": productID, "shelf_id": shelfID}).Decode(&placement)
+	err := p.collection.FindOne(ctx, bson.M{"product_id": productID, "shelf_id": shelfID, "lot_number": lotNumber}).Decode(&placement)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, nil
@@ -95,4 +201,292 @@ func (p *productPlacementRepository) GetProductPlacementsByShelfID(ctx context.C
 	}
 
 	return placements, nil
-}
\ No newline at end of file
+}
+
+func (p *productPlacementRepository) UpdatePlacementLocations(ctx context.Context, storages []*model.Storage) error {
+
+	if len(storages) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(storages))
+	for _, storage := range storages {
+		models = append(models, mongo.NewUpdateManyModel().
+			SetFilter(bson.M{"shelf_id": storage.ID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"path":         storage.Path,
+				"ancestor_ids": storage.AncestorIDs,
+				"updated_at":   time.Now(),
+			}}))
+	}
+
+	_, err := p.collection.BulkWrite(ctx, models)
+	return err
+}
+
+func (p *productPlacementRepository) CountStockedPlacements(ctx context.Context, shelfIDs []primitive.ObjectID) (int64, error) {
+	return p.collection.CountDocuments(ctx, bson.M{
+		"shelf_id":    bson.M{"$in": shelfIDs},
+		"current_qty": bson.M{"$gt": 0},
+	})
+}
+
+func (p *productPlacementRepository) DeletePlacementsByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) error {
+	_, err := p.collection.DeleteMany(ctx, bson.M{"shelf_id": bson.M{"$in": shelfIDs}})
+	return err
+}
+
+// SumQuantityByStorageIDs returns the quantity held on each given storage,
+// counting placements on the storage itself and on any shelf below it.
+func (p *productPlacementRepository) SumQuantityByStorageIDs(ctx context.Context, storageIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+
+	totals := make(map[primitive.ObjectID]int)
+	if len(storageIDs) == 0 {
+		return totals, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$or": bson.A{
+			bson.M{"shelf_id": bson.M{"$in": storageIDs}},
+			bson.M{"ancestor_ids": bson.M{"$in": storageIDs}},
+		}}}},
+		{{Key: "$project", Value: bson.M{
+			"current_qty": 1,
+			"storage_ids": bson.M{"$concatArrays": bson.A{"$ancestor_ids", bson.A{"$shelf_id"}}},
+		}}},
+		{{Key: "$unwind", Value: "$storage_ids"}},
+		{{Key: "$match", Value: bson.M{"storage_ids": bson.M{"$in": storageIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$storage_ids",
+			"total": bson.M{"$sum": "$current_qty"},
+		}}},
+	}
+
+	cursor, err := p.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Total int                `bson:"total"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		totals[row.ID] = row.Total
+	}
+
+	return totals, nil
+}
+
+// GetQuantitiesByShelfIDs returns the non-zero quantity of each product on
+// each given shelf, keyed by shelf id and then product id.
+func (p *productPlacementRepository) GetQuantitiesByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) (map[primitive.ObjectID]map[primitive.ObjectID]int, error) {
+
+	quantities := make(map[primitive.ObjectID]map[primitive.ObjectID]int)
+	if len(shelfIDs) == 0 {
+		return quantities, nil
+	}
+
+	filter := bson.M{
+		"shelf_id":    bson.M{"$in": shelfIDs},
+		"current_qty": bson.M{"$ne": 0},
+	}
+
+	cursor, err := p.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var placement ProductPlacement
+		if err := cursor.Decode(&placement); err != nil {
+			return nil, err
+		}
+		if quantities[placement.ShelfID] == nil {
+			quantities[placement.ShelfID] = make(map[primitive.ObjectID]int)
+		}
+		quantities[placement.ShelfID][placement.ProductID] += placement.CurrentQty
+	}
+
+	return quantities, nil
+}
+
+// GetStockedLots returns the placements of the product on the shelf that still
+// hold stock, one per lot.
+func (p *productPlacementRepository) GetStockedLots(ctx context.Context, productID, shelfID primitive.ObjectID) ([]*ProductPlacement, error) {
+
+	filter := bson.M{
+		"product_id":  productID,
+		"shelf_id":    shelfID,
+		"current_qty": bson.M{"$gt": 0},
+	}
+
+	cursor, err := p.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var placements []*ProductPlacement
+	if err := cursor.All(ctx, &placements); err != nil {
+		return nil, err
+	}
+
+	return placements, nil
+}
+
+// GetLot returns any placement of the product's lot, on whichever shelf.
+func (p *productPlacementRepository) GetLot(ctx context.Context, productID primitive.ObjectID, lotNumber string) (*ProductPlacement, error) {
+
+	var placement ProductPlacement
+
+	err := p.collection.FindOne(ctx, bson.M{"product_id": productID, "lot_number": lotNumber}).Decode(&placement)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &placement, nil
+}
+
+// GetExpiringPlacements returns stocked placements whose lot expires before
+// filter.Before, earliest first. Already expired lots are included.
+func (p *productPlacementRepository) GetExpiringPlacements(ctx context.Context, filter *ExpiringFilter) ([]*ProductPlacement, error) {
+
+	query := bson.M{
+		"expiry_date": bson.M{"$lt": filter.Before},
+		"current_qty": bson.M{"$gt": 0},
+	}
+	if filter.AncestorID != nil {
+		query["$or"] = bson.A{
+			bson.M{"shelf_id": filter.AncestorID},
+			bson.M{"ancestor_ids": filter.AncestorID},
+		}
+	}
+	if filter.ProductID != nil {
+		query["product_id"] = filter.ProductID
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "expiry_date", Value: 1}, {Key: "path", Value: 1}})
+
+	cursor, err := p.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var placements []*ProductPlacement
+	if err := cursor.All(ctx, &placements); err != nil {
+		return nil, err
+	}
+
+	return placements, nil
+}
+
+// SumProductQuantity returns the quantity of the product on hand in the
+// storage's subtree, or everywhere when storageID is nil.
+func (p *productPlacementRepository) SumProductQuantity(ctx context.Context, productID primitive.ObjectID, storageID *primitive.ObjectID) (int, error) {
+
+	match := bson.M{"product_id": productID}
+	if storageID != nil {
+		match["$or"] = bson.A{
+			bson.M{"shelf_id": storageID},
+			bson.M{"ancestor_ids": storageID},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$current_qty"},
+		}}},
+	}
+
+	cursor, err := p.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	return rows[0].Total, nil
+}
+
+func (p *productPlacementRepository) GetShelfStocks(ctx context.Context, productIDs []primitive.ObjectID) ([]*ShelfStock, error) {
+
+	if len(productIDs) == 0 {
+		return nil, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"product_id":  bson.M{"$in": productIDs},
+			"current_qty": bson.M{"$gt": 0},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          bson.M{"product_id": "$product_id", "shelf_id": "$shelf_id"},
+			"ancestor_ids": bson.M{"$first": "$ancestor_ids"},
+			"quantity":     bson.M{"$sum": "$current_qty"},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"product_id":   "$_id.product_id",
+			"shelf_id":     "$_id.shelf_id",
+			"ancestor_ids": 1,
+			"quantity":     1,
+		}}},
+	}
+
+	cursor, err := p.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var stocks []*ShelfStock
+	if err := cursor.All(ctx, &stocks); err != nil {
+		return nil, err
+	}
+
+	return stocks, nil
+}
+
+// ForEachPlacement streams every placement to fn one at a time.
+func (p *productPlacementRepository) ForEachPlacement(ctx context.Context, fn func(*ProductPlacement) error) error {
+
+	cursor, err := p.collection.Find(ctx, bson.M{}, options.Find().SetBatchSize(500))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var placement ProductPlacement
+		if err := cursor.Decode(&placement); err != nil {
+			return err
+		}
+		if err := fn(&placement); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
@@ -1,13 +1,33 @@
 package productplacement
 
+import "time"
+
 type CreateProductPlacementRequest struct {
-	ProductID   string `json:"product_id"`
-	ShelfID     string `json:"shelf_id"`
-	CurrentQty  int    `json:"current_qty"`
+	ProductID  string     `json:"product_id"`
+	ShelfID    string     `json:"shelf_id"`
+	CurrentQty int        `json:"current_qty"`
+	LotNumber  string     `json:"lot_number"`
+	ExpiryDate *time.Time `json:"expiry_date"`
 }
 
 type UpdateProductPlacementRequest struct {
-	ProductID   string `json:"product_id"`
-	ShelfID     string `json:"shelf_id"`
-	CurrentQty  int    `json:"current_qty"`
-}
\ No newline at end of file
+	ProductID  string `json:"product_id"`
+	ShelfID    string `json:"shelf_id"`
+	CurrentQty int    `json:"current_qty"`
+	LotNumber  string `json:"lot_number"`
+}
+
+// AllocateLotsRequest asks which lots to take Quantity from. An empty
+// LotNumber picks first-expiring-first-out.
+type AllocateLotsRequest struct {
+	ProductID string
+	ShelfID   string
+	LotNumber string
+	Quantity  int
+}
+
+type GetExpiringLotsQuery struct {
+	Days        int
+	WarehouseID string
+	ProductID   string
+}
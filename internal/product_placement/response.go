@@ -0,0 +1,61 @@
+package productplacement
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LotAllocation is the quantity to take from one lot of a placement.
+type LotAllocation struct {
+	LotNumber  string
+	ExpiryDate *time.Time
+	Quantity   int
+}
+
+type ExpiringLot struct {
+	ProductID  primitive.ObjectID `json:"product_id"`
+	LotNumber  string             `json:"lot_number"`
+	ExpiryDate time.Time          `json:"expiry_date"`
+	DaysLeft   int                `json:"days_left"`
+	Expired    bool               `json:"expired"`
+	ShelfID    primitive.ObjectID `json:"shelf_id"`
+	Path       string             `json:"path"`
+	Quantity   int                `json:"quantity"`
+}
+
+// ExpiringWarehouse groups expiring lots under the root storage of the
+// shelves holding them.
+type ExpiringWarehouse struct {
+	WarehouseID   primitive.ObjectID `json:"warehouse_id"`
+	WarehouseName string             `json:"warehouse_name"`
+	Quantity      int                `json:"quantity"`
+	Lots          []*ExpiringLot     `json:"lots"`
+}
+
+type ExpiringLotsResponse struct {
+	Days       int                  `json:"days"`
+	Before     time.Time            `json:"before"`
+	Warehouses []*ExpiringWarehouse `json:"warehouses"`
+}
+
+// StockNode is a storage above the shelves holding a product, with the
+// quantity held anywhere below it.
+type StockNode struct {
+	StorageID primitive.ObjectID `json:"storage_id"`
+	Name      string             `json:"name"`
+	Type      string             `json:"type"`
+	Path      string             `json:"path"`
+	Quantity  int                `json:"quantity"`
+	Children  []*StockNode       `json:"children"`
+}
+
+type ProductStockResponse struct {
+	ProductID  primitive.ObjectID `json:"product_id"`
+	Total      int                `json:"total"`
+	Warehouses []*StockNode       `json:"warehouses"`
+}
+
+type ProductStockListResponse struct {
+	Items []*ProductStockResponse `json:"items"`
+}
@@ -13,6 +13,13 @@ func RegisterRoutes(r *gin.Engine, handler *ProductPlacementHandler) {
 		{
 			location.GET("/shelf/:id", handler.GetProductPlacementsByShelfID)
 			location.GET("/product/:id", handler.GetProductPlacementsByProductID)
+			location.GET("/expiring", handler.GetExpiringLots)
+		}
+
+		products := api.Group("/products").Use(middleware.Secured())
+		{
+			products.GET("/stock", handler.GetProductsStock)
+			products.GET("/:id/stock", handler.GetProductStock)
 		}
 	}
 }
\ No newline at end of file
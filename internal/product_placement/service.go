@@ -2,30 +2,50 @@ package productplacement
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"inventory-service/internal/shared/model"
 	"inventory-service/internal/storage"
+	"math"
+	"sort"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var (
+	// ErrInsufficientCapacity and ErrInsufficientStock report that a
+	// conditional update lost against the shelf's current state, e.g. to a
+	// concurrent transaction.
+	ErrInsufficientCapacity = errors.New("not enough stock capacity")
+	ErrInsufficientStock    = errors.New("not enough stock to OUT")
+	// ErrShelfFrozen reports a shelf locked by a running cycle count.
+	ErrShelfFrozen = errors.New("shelf is frozen by a cycle count")
+)
+
 type ProductPlacementService interface {
 	GetProductPlacementsByShelfID(ctx context.Context, shelfId string) ([]*ProductPlacement, error)
 	GetProductPlacementsByProductID(ctx context.Context, productId string) ([]*ProductPlacement, error)
 	CreateProductPlacement(ctx context.Context, req *CreateProductPlacementRequest) error
 	UpdateProductPlacement(ctx context.Context, req *UpdateProductPlacementRequest) error
+	AllocateLots(ctx context.Context, req *AllocateLotsRequest) ([]*LotAllocation, error)
+	GetExpiringLots(ctx context.Context, query *GetExpiringLotsQuery) (*ExpiringLotsResponse, error)
+	GetProductStock(ctx context.Context, productID string) (*ProductStockResponse, error)
+	GetProductsStock(ctx context.Context, productIDs []string) (*ProductStockListResponse, error)
 }
 
 type productPlacementService struct {
 	repository        ProductPlacementRepository
 	storageRepository storage.StorageRepository
+	typeRules         *storage.TypeRules
 }
 
-func NewProductPlacementService(repository ProductPlacementRepository, storageRepository storage.StorageRepository) ProductPlacementService {
+func NewProductPlacementService(repository ProductPlacementRepository, storageRepository storage.StorageRepository, typeRules *storage.TypeRules) ProductPlacementService {
 	return &productPlacementService{
 		repository:        repository,
 		storageRepository: storageRepository,
+		typeRules:         typeRules,
 	}
 }
 
@@ -56,41 +76,77 @@ func (p *productPlacementService) CreateProductPlacement(ctx context.Context, re
 		return fmt.Errorf("invalid shelf id: %v", err)
 	}
 
-	placement, err := p.repository.GetByProductAndShelf(sc, objProductID, objShelfID)
+	storage, err := p.storageRepository.GetStorageByID(sc, &objShelfID)
 	if err != nil {
 		return err
 	}
+	if storage == nil {
+		return fmt.Errorf("shelf not found")
+	}
+	if !p.typeRules.CanHoldPlacements(storage.Type) {
+		return fmt.Errorf("storage type %q cannot hold products", storage.Type)
+	}
+	if storage.FrozenBy != nil {
+		return ErrShelfFrozen
+	}
+	if storage.TotalStock == nil {
+		return fmt.Errorf("shelf has no stock capacity")
+	}
 
-	storage, err := p.storageRepository.GetStorageByID(sc, &objShelfID)
+	expiryDate, err := p.lotExpiry(sc, objProductID, req.LotNumber, req.ExpiryDate)
 	if err != nil {
 		return err
 	}
-	if storage.TotalStock == nil || *storage.TotalStock < req.CurrentQty {
-		return fmt.Errorf("not enough stock capacity")
+
+	// The capacity check and the decrement happen in one conditional update so
+	// concurrent IN transactions cannot both pass the check.
+	reserved, err := p.storageRepository.IncrementTotalStock(sc, objShelfID, -req.CurrentQty)
+	if err != nil {
+		return err
 	}
-	
-	if placement != nil {
-		err = p.repository.UpdateProductPlacement(sc, objProductID, objShelfID, placement.CurrentQty+req.CurrentQty)
-		if err != nil {
-			return err
-		}
-	} else {
-		data := &ProductPlacement{
-			ID:          primitive.NewObjectID(),
-			ProductID:   objProductID,
-			ShelfID:     objShelfID,
-			CurrentQty:  req.CurrentQty,
-			Path:        storage.Path,
-			AncestorIDs: storage.AncestorIDs,
-			CreatedAt:   time.Now(),
-			UpdatedAt:   time.Now(),
-		}
-		if err := p.repository.CreateProductPlacement(sc, data); err != nil {
-			return err
+	if !reserved {
+		return ErrInsufficientCapacity
+	}
+
+	now := time.Now()
+	return p.repository.AddQuantity(sc, &ProductPlacement{
+		ID:          primitive.NewObjectID(),
+		ProductID:   objProductID,
+		ShelfID:     objShelfID,
+		CurrentQty:  req.CurrentQty,
+		Path:        storage.Path,
+		AncestorIDs: storage.AncestorIDs,
+		LotNumber:   req.LotNumber,
+		ExpiryDate:  expiryDate,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	})
+}
+
+// lotExpiry keeps one expiry date per lot: receiving more of a known lot
+// inherits its date, and a conflicting date is rejected.
+func (p *productPlacementService) lotExpiry(ctx context.Context, productID primitive.ObjectID, lotNumber string, expiryDate *time.Time) (*time.Time, error) {
+
+	if lotNumber == "" {
+		if expiryDate != nil {
+			return nil, fmt.Errorf("expiry_date requires a lot_number")
 		}
+		return nil, nil
+	}
+
+	lot, err := p.repository.GetLot(ctx, productID, lotNumber)
+	if err != nil {
+		return nil, err
+	}
+	if lot == nil || lot.ExpiryDate == nil {
+		return expiryDate, nil
+	}
+
+	if expiryDate != nil && !expiryDate.Equal(*lot.ExpiryDate) {
+		return nil, fmt.Errorf("lot %q already has expiry date %s", lotNumber, lot.ExpiryDate.Format("2006-01-02"))
 	}
 
-	return p.storageRepository.UpdateTotalStock(sc, objShelfID, *storage.TotalStock-req.CurrentQty)
+	return lot.ExpiryDate, nil
 }
 
 func (p *productPlacementService) UpdateProductPlacement(ctx context.Context, req *UpdateProductPlacementRequest) error {
@@ -122,36 +178,345 @@ func (p *productPlacementService) UpdateProductPlacement(ctx context.Context, re
 		return fmt.Errorf("invalid shelf id: %v", err)
 	}
 
-	placement, err := p.repository.GetByProductAndShelf(sc, objProductID, objShelfID)
+	storage, err := p.storageRepository.GetStorageByID(sc, &objShelfID)
 	if err != nil {
 		return err
 	}
+	if storage != nil && storage.FrozenBy != nil {
+		return ErrShelfFrozen
+	}
 
-	if placement == nil {
-		return fmt.Errorf("product placement not found")
+	removed, err := p.repository.RemoveQuantity(sc, objProductID, objShelfID, req.LotNumber, req.CurrentQty)
+	if err != nil {
+		return err
 	}
 
-	newQty := placement.CurrentQty - req.CurrentQty
-	if newQty < 0 {
-		return fmt.Errorf("not enough stock to OUT")
+	if !removed {
+		placement, err := p.repository.GetByProductAndShelf(sc, objProductID, objShelfID, req.LotNumber)
+		if err != nil {
+			return err
+		}
+		if placement == nil {
+			return fmt.Errorf("product placement not found")
+		}
+		return ErrInsufficientStock
 	}
 
-	err = p.repository.UpdateProductPlacement(sc, objProductID, objShelfID, newQty)
+	released, err := p.storageRepository.IncrementTotalStock(sc, objShelfID, req.CurrentQty)
 	if err != nil {
 		return err
 	}
+	if !released {
+		return fmt.Errorf("shelf not found")
+	}
 
-	storage, err := p.storageRepository.GetStorageByID(sc, &objShelfID)
+	return nil
+}
+
+// AllocateLots decides which lots of the product on the shelf a removal takes
+// stock from: the named lot, or first-expiring-first-out when none is named.
+func (p *productPlacementService) AllocateLots(ctx context.Context, req *AllocateLotsRequest) ([]*LotAllocation, error) {
+
+	if req.Quantity <= 0 {
+		return nil, fmt.Errorf("quantity must be greater than 0")
+	}
+
+	objProductID, err := primitive.ObjectIDFromHex(req.ProductID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid product id: %v", err)
 	}
 
-	err = p.storageRepository.UpdateTotalStock(sc, objShelfID, *storage.TotalStock+req.CurrentQty)
+	objShelfID, err := primitive.ObjectIDFromHex(req.ShelfID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("invalid shelf id: %v", err)
 	}
 
-	return nil
+	lots, err := p.repository.GetStockedLots(ctx, objProductID, objShelfID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.LotNumber == "" {
+		if len(lots) == 0 {
+			placement, err := p.repository.GetByProductAndShelf(ctx, objProductID, objShelfID, "")
+			if err != nil {
+				return nil, err
+			}
+			if placement == nil {
+				return nil, fmt.Errorf("product placement not found")
+			}
+			return nil, ErrInsufficientStock
+		}
+		allocations, ok := AllocateFEFO(lots, req.Quantity)
+		if !ok {
+			return nil, ErrInsufficientStock
+		}
+		return allocations, nil
+	}
+
+	for _, lot := range lots {
+		if lot.LotNumber != req.LotNumber {
+			continue
+		}
+		if lot.CurrentQty < req.Quantity {
+			return nil, ErrInsufficientStock
+		}
+		return []*LotAllocation{{LotNumber: lot.LotNumber, ExpiryDate: lot.ExpiryDate, Quantity: req.Quantity}}, nil
+	}
+
+	return nil, fmt.Errorf("lot %q not found on shelf", req.LotNumber)
+}
+
+// AllocateFEFO takes qty from the lots that expire first. Lots without an
+// expiry date, including stock received without a lot, go last. It reports
+// false when the lots hold less than qty.
+func AllocateFEFO(lots []*ProductPlacement, qty int) ([]*LotAllocation, bool) {
+
+	sorted := make([]*ProductPlacement, len(lots))
+	copy(sorted, lots)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i].ExpiryDate, sorted[j].ExpiryDate
+		switch {
+		case a == nil || b == nil:
+			if (a == nil) != (b == nil) {
+				return b == nil
+			}
+		case !a.Equal(*b):
+			return a.Before(*b)
+		}
+		return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+	})
+
+	var allocations []*LotAllocation
+	for _, lot := range sorted {
+		if qty == 0 {
+			break
+		}
+		if lot.CurrentQty <= 0 {
+			continue
+		}
+		take := lot.CurrentQty
+		if take > qty {
+			take = qty
+		}
+		allocations = append(allocations, &LotAllocation{LotNumber: lot.LotNumber, ExpiryDate: lot.ExpiryDate, Quantity: take})
+		qty -= take
+	}
+
+	return allocations, qty == 0
+}
+
+const (
+	defaultExpiringDays = 30
+	maxExpiringDays     = 3650
+)
+
+// GetExpiringLots lists lots expiring within query.Days, already expired ones
+// included, grouped by the warehouse at the root of their shelf.
+func (p *productPlacementService) GetExpiringLots(ctx context.Context, query *GetExpiringLotsQuery) (*ExpiringLotsResponse, error) {
+
+	days := query.Days
+	if days == 0 {
+		days = defaultExpiringDays
+	}
+	if days < 0 || days > maxExpiringDays {
+		return nil, fmt.Errorf("days must be between 1 and %d", maxExpiringDays)
+	}
+
+	now := time.Now()
+	filter := &ExpiringFilter{Before: now.AddDate(0, 0, days)}
+
+	if query.WarehouseID != "" {
+		warehouseID, err := primitive.ObjectIDFromHex(query.WarehouseID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid warehouse id: %v", err)
+		}
+		filter.AncestorID = &warehouseID
+	}
+
+	if query.ProductID != "" {
+		productID, err := primitive.ObjectIDFromHex(query.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id: %v", err)
+		}
+		filter.ProductID = &productID
+	}
+
+	placements, err := p.repository.GetExpiringPlacements(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ExpiringLotsResponse{Days: days, Before: filter.Before, Warehouses: []*ExpiringWarehouse{}}
+
+	byWarehouse := make(map[primitive.ObjectID]*ExpiringWarehouse)
+	var warehouseIDs []primitive.ObjectID
+
+	for _, placement := range placements {
+		warehouseID := placement.ShelfID
+		if len(placement.AncestorIDs) > 0 {
+			warehouseID = placement.AncestorIDs[0]
+		}
+
+		warehouse, ok := byWarehouse[warehouseID]
+		if !ok {
+			warehouse = &ExpiringWarehouse{WarehouseID: warehouseID, Lots: []*ExpiringLot{}}
+			byWarehouse[warehouseID] = warehouse
+			warehouseIDs = append(warehouseIDs, warehouseID)
+			response.Warehouses = append(response.Warehouses, warehouse)
+		}
+
+		expiryDate := *placement.ExpiryDate
+		warehouse.Quantity += placement.CurrentQty
+		warehouse.Lots = append(warehouse.Lots, &ExpiringLot{
+			ProductID:  placement.ProductID,
+			LotNumber:  placement.LotNumber,
+			ExpiryDate: expiryDate,
+			DaysLeft:   int(math.Ceil(expiryDate.Sub(now).Hours() / 24)),
+			Expired:    !expiryDate.After(now),
+			ShelfID:    placement.ShelfID,
+			Path:       placement.Path,
+			Quantity:   placement.CurrentQty,
+		})
+	}
+
+	warehouses, err := p.storageRepository.GetStoragesByIDs(ctx, warehouseIDs)
+	if err != nil {
+		return nil, err
+	}
+	for _, storage := range warehouses {
+		if warehouse, ok := byWarehouse[storage.ID]; ok {
+			warehouse.WarehouseName = storage.Name
+		}
+	}
+
+	sort.SliceStable(response.Warehouses, func(i, j int) bool {
+		return response.Warehouses[i].WarehouseName < response.Warehouses[j].WarehouseName
+	})
+
+	return response, nil
+}
+
+const maxStockProducts = 100
+
+func (p *productPlacementService) GetProductStock(ctx context.Context, productID string) (*ProductStockResponse, error) {
+
+	stocks, err := p.GetProductsStock(ctx, []string{productID})
+	if err != nil {
+		return nil, err
+	}
+
+	return stocks.Items[0], nil
+}
+
+// GetProductsStock rolls the stock of each product up the storage hierarchy,
+// from the storages directly holding its shelves to the warehouses at the
+// root. A shelf without a parent is listed as its own warehouse.
+func (p *productPlacementService) GetProductsStock(ctx context.Context, productIDs []string) (*ProductStockListResponse, error) {
+
+	if len(productIDs) == 0 {
+		return nil, fmt.Errorf("product_ids is required")
+	}
+
+	if len(productIDs) > maxStockProducts {
+		return nil, fmt.Errorf("too many products, at most %d per request", maxStockProducts)
+	}
+
+	response := &ProductStockListResponse{Items: make([]*ProductStockResponse, 0, len(productIDs))}
+	byProduct := make(map[primitive.ObjectID]*ProductStockResponse, len(productIDs))
+	objProductIDs := make([]primitive.ObjectID, 0, len(productIDs))
+
+	for _, productID := range productIDs {
+		objProductID, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id %q: %v", productID, err)
+		}
+		if _, ok := byProduct[objProductID]; ok {
+			continue
+		}
+
+		item := &ProductStockResponse{ProductID: objProductID, Warehouses: []*StockNode{}}
+		byProduct[objProductID] = item
+		objProductIDs = append(objProductIDs, objProductID)
+		response.Items = append(response.Items, item)
+	}
+
+	stocks, err := p.repository.GetShelfStocks(ctx, objProductIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	type nodeKey struct {
+		productID primitive.ObjectID
+		storageID primitive.ObjectID
+	}
+
+	nodes := make(map[nodeKey]*StockNode)
+	var storageIDs []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+
+	for _, stock := range stocks {
+		item := byProduct[stock.ProductID]
+		item.Total += stock.Quantity
+
+		chain := stock.AncestorIDs
+		if len(chain) == 0 {
+			chain = []primitive.ObjectID{stock.ShelfID}
+		}
+
+		siblings := &item.Warehouses
+		for _, storageID := range chain {
+			key := nodeKey{productID: stock.ProductID, storageID: storageID}
+			node, ok := nodes[key]
+			if !ok {
+				node = &StockNode{StorageID: storageID, Children: []*StockNode{}}
+				nodes[key] = node
+				*siblings = append(*siblings, node)
+			}
+			node.Quantity += stock.Quantity
+			siblings = &node.Children
+
+			if !seen[storageID] {
+				seen[storageID] = true
+				storageIDs = append(storageIDs, storageID)
+			}
+		}
+	}
+
+	storages, err := p.storageRepository.GetStoragesByIDs(ctx, storageIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]*model.Storage, len(storages))
+	for _, storage := range storages {
+		byID[storage.ID] = storage
+	}
+
+	for key, node := range nodes {
+		if storage, ok := byID[key.storageID]; ok {
+			node.Name = storage.Name
+			node.Type = storage.Type
+			node.Path = storage.Path
+		}
+	}
+
+	for _, item := range response.Items {
+		sortStockNodes(item.Warehouses)
+	}
+
+	return response, nil
+}
+
+func sortStockNodes(nodes []*StockNode) {
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	for _, node := range nodes {
+		sortStockNodes(node.Children)
+	}
 }
 
 func (p *productPlacementService) GetProductPlacementsByProductID(ctx context.Context, productID string) ([]*ProductPlacement, error) {
@@ -0,0 +1,163 @@
+package productplacement_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+
+	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/shared/mongotest"
+	"inventory-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	shelfSlots  = 4
+	shelfLevels = 5
+	workers     = 40
+)
+
+func TestConcurrentInOutKeepsShelfInvariants(t *testing.T) {
+
+	client, database := mongotest.Database(t)
+	ctx := context.Background()
+
+	storageRepository := storage.NewStorageRepository(database.Collection("storage"))
+	placementRepository := productplacement.NewProductPlacementRepository(database.Collection("product_placement"))
+	if err := placementRepository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+	service := productplacement.NewProductPlacementService(placementRepository, storageRepository, mongotest.TypeRules())
+
+	shelf := mongotest.AddShelf(t, storageRepository, shelfSlots, shelfLevels)
+	productID := primitive.NewObjectID().Hex()
+
+	run := func(apply func(sc mongo.SessionContext) error) error {
+		session, err := client.StartSession()
+		if err != nil {
+			return err
+		}
+		defer session.EndSession(ctx)
+
+		_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			return nil, apply(sc)
+		})
+		return err
+	}
+	in := func(qty int) func(sc mongo.SessionContext) error {
+		return func(sc mongo.SessionContext) error {
+			return service.CreateProductPlacement(sc, &productplacement.CreateProductPlacementRequest{ProductID: productID, ShelfID: shelf.ID.Hex(), CurrentQty: qty})
+		}
+	}
+	out := func(qty int) func(sc mongo.SessionContext) error {
+		return func(sc mongo.SessionContext) error {
+			return service.UpdateProductPlacement(sc, &productplacement.UpdateProductPlacementRequest{ProductID: productID, ShelfID: shelf.ID.Hex(), CurrentQty: qty})
+		}
+	}
+
+	// Seed the placement so every OUT finds it and can only fail on stock.
+	if err := run(in(shelfSlots)); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	stop := mongotest.Watch(t, database, shelf.ID)
+	errs := mongotest.RunConcurrently(workers, func(i int) error {
+		qty := rand.Intn(shelfLevels) + 1
+		if i%2 == 0 {
+			return run(in(qty))
+		}
+		return run(out(qty))
+	})
+	stop()
+
+	checkLosers(t, errs)
+	mongotest.CheckInvariant(t, database, shelf)
+}
+
+// TestConcurrentRepositoryUpdatesOutsideTransaction drives the conditional
+// updates without a transaction, so only their filters keep the shelf from
+// going negative.
+func TestConcurrentRepositoryUpdatesOutsideTransaction(t *testing.T) {
+
+	_, database := mongotest.Database(t)
+	ctx := context.Background()
+
+	storageRepository := storage.NewStorageRepository(database.Collection("storage"))
+	placementRepository := productplacement.NewProductPlacementRepository(database.Collection("product_placement"))
+	if err := placementRepository.EnsureIndexes(ctx); err != nil {
+		t.Fatalf("ensure indexes: %v", err)
+	}
+
+	shelf := mongotest.AddShelf(t, storageRepository, shelfSlots, shelfLevels)
+	productID := primitive.NewObjectID()
+
+	in := func(qty int) error {
+		reserved, err := storageRepository.IncrementTotalStock(ctx, shelf.ID, -qty)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return productplacement.ErrInsufficientCapacity
+		}
+		return placementRepository.AddQuantity(ctx, &productplacement.ProductPlacement{
+			ID:          primitive.NewObjectID(),
+			ProductID:   productID,
+			ShelfID:     shelf.ID,
+			CurrentQty:  qty,
+			Path:        shelf.Path,
+			AncestorIDs: shelf.AncestorIDs,
+		})
+	}
+	out := func(qty int) error {
+		removed, err := placementRepository.RemoveQuantity(ctx, productID, shelf.ID, "", qty)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return productplacement.ErrInsufficientStock
+		}
+		_, err = storageRepository.IncrementTotalStock(ctx, shelf.ID, qty)
+		return err
+	}
+
+	if err := in(shelfSlots); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	stop := mongotest.Watch(t, database, shelf.ID)
+	errs := mongotest.RunConcurrently(workers, func(i int) error {
+		qty := rand.Intn(shelfLevels) + 1
+		if i%2 == 0 {
+			return in(qty)
+		}
+		return out(qty)
+	})
+	stop()
+
+	checkLosers(t, errs)
+	mongotest.CheckInvariant(t, database, shelf)
+}
+
+// checkLosers fails unless some request succeeded and every other one lost
+// on capacity or stock.
+func checkLosers(t *testing.T, errs []error) {
+
+	t.Helper()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if !errors.Is(err, productplacement.ErrInsufficientCapacity) && !errors.Is(err, productplacement.ErrInsufficientStock) {
+			t.Errorf("losing request got %v, want ErrInsufficientCapacity or ErrInsufficientStock", err)
+		}
+	}
+	if succeeded == 0 {
+		t.Errorf("no request succeeded")
+	}
+}
@@ -2,9 +2,13 @@ package producttransaction
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"inventory-service/helper"
+	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/reservation"
 	"inventory-service/pkg/constants"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -28,6 +32,8 @@ func (h *ProductTransactionHandler) CreateProductTransaction(c *gin.Context) {
 		return
 	}
 
+	req.IdempotencyKey = c.GetHeader("Idempotency-Key")
+
 	userID , exists := c.Get(constants.UserID)
 	if !exists {
 		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
@@ -44,6 +50,10 @@ func (h *ProductTransactionHandler) CreateProductTransaction(c *gin.Context) {
 
 	productTransactionID, err := h.ProductTransactionService.CreateProductTransaction(ctx, &req, userID.(string))
 	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
 		return
 	}
@@ -51,3 +61,189 @@ func (h *ProductTransactionHandler) CreateProductTransaction(c *gin.Context) {
 	helper.SendSuccess(c, 200, "Create product transaction successfully", productTransactionID)
 	
 }
+
+func (h *ProductTransactionHandler) GetProductTransactions(c *gin.Context) {
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	query := &GetProductTransactionsQuery{
+		Page:       page,
+		Size:       size,
+		ProductID:  c.Query("product_id"),
+		ShelfID:    c.Query("shelf_id"),
+		AncestorID: c.Query("ancestor_id"),
+		Document:   c.Query("document_number"),
+		GroupID:    c.Query("group_id"),
+		LotNumber:  c.Query("lot_number"),
+		Action:     c.Query("action"),
+		ActionBy:   c.Query("action_by"),
+		From:       c.Query("from"),
+		To:         c.Query("to"),
+		Sort:       c.Query("sort"),
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	transactions, err := h.ProductTransactionService.GetProductTransactions(ctx, query)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get product transactions successfully", transactions)
+
+}
+
+func (h *ProductTransactionHandler) GetProductTransactionByID(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	transaction, err := h.ProductTransactionService.GetProductTransactionByID(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get product transaction successfully", transaction)
+
+}
+
+func (h *ProductTransactionHandler) ReverseProductTransaction(c *gin.Context) {
+
+	id := c.Param("id")
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	reversalID, err := h.ProductTransactionService.ReverseProductTransaction(ctx, id, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Reverse product transaction successfully", reversalID)
+
+}
+
+func (h *ProductTransactionHandler) CreateBatchTransaction(c *gin.Context) {
+
+	var req BatchProductTransactionRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	result, err := h.ProductTransactionService.CreateBatchTransaction(ctx, &req, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	if result.Mode == BatchModeAtomic && result.Failed > 0 {
+		c.JSON(400, helper.APIResponse{
+			StatusCode: 400,
+			Message:    fmt.Sprintf("Batch has %d invalid lines", result.Failed),
+			Data:       result,
+			ErrorCode:  helper.ErrInvalidRequest,
+		})
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Create batch transaction successfully", result)
+
+}
+
+func (h *ProductTransactionHandler) GetDocumentTransactions(c *gin.Context) {
+
+	documentNumber := c.Param("document_number")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	transactions, err := h.ProductTransactionService.GetDocumentTransactions(ctx, documentNumber)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get document transactions successfully", transactions)
+
+}
+
+// isConflict reports errors caused by the current stock state rather than by
+// the request itself.
+func isConflict(err error) bool {
+	return errors.Is(err, productplacement.ErrInsufficientCapacity) ||
+		errors.Is(err, productplacement.ErrInsufficientStock) ||
+		errors.Is(err, productplacement.ErrShelfFrozen) ||
+		errors.Is(err, ErrSerialInStock) ||
+		errors.Is(err, ErrSerialNotOnShelf) ||
+		errors.Is(err, reservation.ErrNotAvailable) ||
+		errors.Is(err, reservation.ErrReservationClosed) ||
+		errors.Is(err, ErrAlreadyReversed) ||
+		errors.Is(err, ErrIdempotencyKeyReused) ||
+		errors.Is(err, ErrDocumentNumberUsed)
+}
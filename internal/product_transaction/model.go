@@ -1,21 +1,103 @@
 package producttransaction
 
 import (
+	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type Action string
+
+const (
+	ActionIn       Action = "IN"
+	ActionOut      Action = "OUT"
+	ActionTransfer Action = "TRANSFER"
+
+	// A TRANSFER is recorded as a linked pair of entries sharing TransferID.
+	ActionTransferOut Action = "TRANSFER_OUT"
+	ActionTransferIn  Action = "TRANSFER_IN"
+
+	// ADJUST entries are posted by approved cycle counts. Their Quantity is
+	// the signed difference between counted and recorded stock.
+	ActionAdjust Action = "ADJUST"
+
+	// RECONCILE entries record a placement that reconciliation corrected to
+	// match the ledger. Their Quantity is the signed change made to the
+	// placement; they do not move ledger stock.
+	ActionReconcile Action = "RECONCILE"
+)
+
+// requestActions are the actions a client may submit; the others only appear
+// in the ledger.
+var requestActions = map[Action]bool{
+	ActionIn:       true,
+	ActionOut:      true,
+	ActionTransfer: true,
+}
+
+// Inverse returns the action that undoes a.
+func (a Action) Inverse() Action {
+
+	switch a {
+	case ActionIn:
+		return ActionOut
+	case ActionOut:
+		return ActionIn
+	case ActionTransferOut:
+		return ActionTransferIn
+	case ActionTransferIn:
+		return ActionTransferOut
+	default:
+		return ""
+	}
+}
+
+func (a Action) Validate() error {
+
+	if a == "" {
+		return fmt.Errorf("action is required")
+	}
+
+	if !requestActions[a] {
+		return fmt.Errorf("unknown action %q, use IN, OUT or TRANSFER", a)
+	}
+
+	return nil
+}
+
 type ProductTransaction struct {
-	ID        primitive.ObjectID `json:"id" bson:"_id"`
-	ProductID primitive.ObjectID `json:"product_id" bson:"product_id"`
-	ShelfID   primitive.ObjectID `json:"shelf_id" bson:"shelf_id"`
-	Quantity  int                `json:"quantity" bson:"quantity"`
-	Action    string             `json:"action" bson:"action"`
-	ActionBy  string             `json:"action_by" bson:"action_by"`
-	ActionAt  time.Time          `json:"action_at" bson:"action_at"`
-	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
-	UpdatedAt time.Time          `json:"updated_at" bson:"updated_at"`
+	ID         primitive.ObjectID  `json:"id" bson:"_id"`
+	ProductID  primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	ShelfID    primitive.ObjectID  `json:"shelf_id" bson:"shelf_id"`
+	Quantity   int                 `json:"quantity" bson:"quantity"`
+	Action     Action              `json:"action" bson:"action"`
+	TransferID *primitive.ObjectID `json:"transfer_id,omitempty" bson:"transfer_id,omitempty"`
+	// GroupID links the entries written for one request: the lots an OUT
+	// was split into and both sides of a transfer. It is the id of the
+	// request's first entry, which is the id returned to the client.
+	GroupID *primitive.ObjectID `json:"group_id,omitempty" bson:"group_id,omitempty"`
+	// LotNumber and ExpiryDate identify the lot the entry moved stock in or
+	// out of; an entry without a lot moved unlotted stock.
+	LotNumber  string     `json:"lot_number,omitempty" bson:"lot_number,omitempty"`
+	ExpiryDate *time.Time `json:"expiry_date,omitempty" bson:"expiry_date,omitempty"`
+	// SerialNumbers lists the units moved when the product is serialized.
+	SerialNumbers []string `json:"serial_numbers,omitempty" bson:"serial_numbers,omitempty"`
+	// ReservationID is the reservation an OUT fulfilled.
+	ReservationID *primitive.ObjectID `json:"reservation_id,omitempty" bson:"reservation_id,omitempty"`
+	// DocumentNumber groups the entries created by one batch.
+	DocumentNumber string              `json:"document_number,omitempty" bson:"document_number,omitempty"`
+	ReversalOf     *primitive.ObjectID `json:"reversal_of,omitempty" bson:"reversal_of,omitempty"`
+	ReversedBy     *primitive.ObjectID `json:"reversed_by,omitempty" bson:"reversed_by,omitempty"`
+	ReversedAt     *time.Time          `json:"reversed_at,omitempty" bson:"reversed_at,omitempty"`
+	// IdempotencyKey is unique per ActionBy; RequestHash fingerprints the
+	// payload first sent with it.
+	IdempotencyKey string    `json:"idempotency_key,omitempty" bson:"idempotency_key,omitempty"`
+	RequestHash    string    `json:"-" bson:"request_hash,omitempty"`
+	ActionBy       string    `json:"action_by" bson:"action_by"`
+	ActionAt       time.Time `json:"action_at" bson:"action_at"`
+	CreatedAt      time.Time `json:"created_at" bson:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at" bson:"updated_at"`
 }
 
 type CreateProductPlacementRequest struct {
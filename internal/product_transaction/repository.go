@@ -2,21 +2,65 @@ package producttransaction
 
 import (
 	"context"
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+type ProductTransactionFilter struct {
+	ProductID      *primitive.ObjectID
+	DocumentNumber string
+	GroupID        *primitive.ObjectID
+	LotNumber      string
+	ShelfIDs       []primitive.ObjectID
+	Actions        []Action
+	ActionBy       string
+	From           *time.Time
+	To             *time.Time
+	SortField      string
+	SortOrder      int
+	Skip           int64
+	Limit          int64
+}
+
 type ProductTransactionRepository interface {
 	CreateProductTransaction(ctx context.Context, data *ProductTransaction) error
+	GetProductTransactions(ctx context.Context, filter *ProductTransactionFilter) ([]*ProductTransaction, int64, error)
+	GetProductTransactionByID(ctx context.Context, id primitive.ObjectID) (*ProductTransaction, error)
+	GetProductTransactionsByTransferID(ctx context.Context, transferID primitive.ObjectID) ([]*ProductTransaction, error)
+	GetProductTransactionsByGroupID(ctx context.Context, groupID primitive.ObjectID) ([]*ProductTransaction, error)
+	MarkReversed(ctx context.Context, id, reversedBy primitive.ObjectID, at time.Time) (bool, error)
+	GetByIdempotencyKey(ctx context.Context, actionBy, key string) (*ProductTransaction, error)
+	ExistsDocumentNumber(ctx context.Context, documentNumber string) (bool, error)
+	ReserveDocumentNumber(ctx context.Context, documentNumber, userID string, at time.Time) (bool, error)
+	SumQuantities(ctx context.Context, filter *ProductTransactionFilter) ([]*StockDelta, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+// StockDelta is the net stock change of a product lot on a shelf over the
+// entries matched by a filter. Entries with an action the ledger does not know
+// are left out of Quantity and counted in UnknownEntries instead.
+type StockDelta struct {
+	ProductID      primitive.ObjectID `bson:"product_id"`
+	ShelfID        primitive.ObjectID `bson:"shelf_id"`
+	LotNumber      string             `bson:"lot_number"`
+	Quantity       int                `bson:"quantity"`
+	UnknownEntries int                `bson:"unknown_entries"`
+	UnknownActions []string           `bson:"unknown_actions"`
 }
 
 type productTransactionRepository struct {
-	collection *mongo.Collection
+	collection         *mongo.Collection
+	documentCollection *mongo.Collection
 }
 
-func NewProductTransactionRepository(collection *mongo.Collection) ProductTransactionRepository {
+func NewProductTransactionRepository(collection, documentCollection *mongo.Collection) ProductTransactionRepository {
 	return &productTransactionRepository{
-		collection: collection,
+		collection:         collection,
+		documentCollection: documentCollection,
 	}
 }
 
@@ -30,4 +74,269 @@ func (p *productTransactionRepository) CreateProductTransaction(ctx context.Cont
 
 	return nil
 	
-}
\ No newline at end of file
+}
+
+func transactionQuery(filter *ProductTransactionFilter) bson.M {
+
+	query := bson.M{}
+	if filter.ProductID != nil {
+		query["product_id"] = filter.ProductID
+	}
+	if filter.DocumentNumber != "" {
+		query["document_number"] = filter.DocumentNumber
+	}
+	if filter.GroupID != nil {
+		query["group_id"] = filter.GroupID
+	}
+	if filter.LotNumber != "" {
+		query["lot_number"] = filter.LotNumber
+	}
+	if filter.ShelfIDs != nil {
+		query["shelf_id"] = bson.M{"$in": filter.ShelfIDs}
+	}
+	if len(filter.Actions) > 0 {
+		query["action"] = bson.M{"$in": filter.Actions}
+	}
+	if filter.ActionBy != "" {
+		query["action_by"] = filter.ActionBy
+	}
+	if filter.From != nil || filter.To != nil {
+		createdAt := bson.M{}
+		if filter.From != nil {
+			createdAt["$gte"] = filter.From
+		}
+		if filter.To != nil {
+			createdAt["$lt"] = filter.To
+		}
+		query["created_at"] = createdAt
+	}
+
+	return query
+}
+
+func (p *productTransactionRepository) GetProductTransactions(ctx context.Context, filter *ProductTransactionFilter) ([]*ProductTransaction, int64, error) {
+
+	query := transactionQuery(filter)
+
+	total, err := p.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: filter.SortField, Value: filter.SortOrder}, {Key: "_id", Value: filter.SortOrder}}).
+		SetSkip(filter.Skip).
+		SetLimit(filter.Limit)
+
+	cursor, err := p.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	transactions := []*ProductTransaction{}
+	for cursor.Next(ctx) {
+		var transaction ProductTransaction
+		if err := cursor.Decode(&transaction); err != nil {
+			return nil, 0, err
+		}
+		transactions = append(transactions, &transaction)
+	}
+
+	return transactions, total, nil
+
+}
+
+func (p *productTransactionRepository) GetProductTransactionByID(ctx context.Context, id primitive.ObjectID) (*ProductTransaction, error) {
+
+	var transaction ProductTransaction
+
+	err := p.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&transaction)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &transaction, nil
+
+}
+
+func (p *productTransactionRepository) GetProductTransactionsByTransferID(ctx context.Context, transferID primitive.ObjectID) ([]*ProductTransaction, error) {
+
+	cursor, err := p.collection.Find(ctx, bson.M{"transfer_id": transferID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var transactions []*ProductTransaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+
+}
+
+func (p *productTransactionRepository) GetProductTransactionsByGroupID(ctx context.Context, groupID primitive.ObjectID) ([]*ProductTransaction, error) {
+
+	cursor, err := p.collection.Find(ctx, bson.M{"group_id": groupID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var transactions []*ProductTransaction
+	if err := cursor.All(ctx, &transactions); err != nil {
+		return nil, err
+	}
+
+	return transactions, nil
+
+}
+
+// MarkReversed links an entry to its compensating entry. It only matches
+// entries that are not reversed yet, so concurrent reversals cannot both win.
+func (p *productTransactionRepository) MarkReversed(ctx context.Context, id, reversedBy primitive.ObjectID, at time.Time) (bool, error) {
+
+	filter := bson.M{"_id": id, "reversed_by": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{
+		"reversed_by": reversedBy,
+		"reversed_at": at,
+		"updated_at":  at,
+	}}
+
+	result, err := p.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+
+}
+
+func (p *productTransactionRepository) GetByIdempotencyKey(ctx context.Context, actionBy, key string) (*ProductTransaction, error) {
+
+	var transaction ProductTransaction
+
+	err := p.collection.FindOne(ctx, bson.M{"action_by": actionBy, "idempotency_key": key}).Decode(&transaction)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &transaction, nil
+
+}
+
+func (p *productTransactionRepository) ExistsDocumentNumber(ctx context.Context, documentNumber string) (bool, error) {
+
+	count, err := p.collection.CountDocuments(ctx, bson.M{"document_number": documentNumber}, options.Count().SetLimit(1))
+	return count > 0, err
+
+}
+
+// ReserveDocumentNumber records the document number under its unique _id. It
+// reports false when another batch already reserved it.
+func (p *productTransactionRepository) ReserveDocumentNumber(ctx context.Context, documentNumber, userID string, at time.Time) (bool, error) {
+
+	_, err := p.documentCollection.InsertOne(ctx, bson.M{"_id": documentNumber, "created_by": userID, "created_at": at})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+
+}
+
+// SumQuantities replays the matched entries into net quantities per product,
+// shelf and lot. OUT and TRANSFER_OUT remove stock, ADJUST carries its own
+// sign. Entries written before lots existed count as unlotted stock.
+func (p *productTransactionRepository) SumQuantities(ctx context.Context, filter *ProductTransactionFilter) ([]*StockDelta, error) {
+
+	knownActions := bson.A{ActionIn, ActionTransferIn, ActionOut, ActionTransferOut, ActionAdjust, ActionReconcile}
+	unknown := bson.M{"$not": bson.A{bson.M{"$in": bson.A{"$action", knownActions}}}}
+
+	// ADJUST quantities are already signed; RECONCILE and unknown actions do
+	// not move stock.
+	signed := bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$in": bson.A{"$action", bson.A{ActionIn, ActionTransferIn}}}, "then": "$quantity"},
+			bson.M{"case": bson.M{"$in": bson.A{"$action", bson.A{ActionOut, ActionTransferOut}}}, "then": bson.M{"$multiply": bson.A{"$quantity", -1}}},
+			bson.M{"case": bson.M{"$eq": bson.A{"$action", ActionAdjust}}, "then": "$quantity"},
+		},
+		"default": 0,
+	}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: transactionQuery(filter)}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"product_id": "$product_id",
+				"shelf_id":   "$shelf_id",
+				"lot_number": bson.M{"$ifNull": bson.A{"$lot_number", ""}},
+			},
+			"quantity":        bson.M{"$sum": signed},
+			"unknown_entries": bson.M{"$sum": bson.M{"$cond": bson.A{unknown, 1, 0}}},
+			"unknown_actions": bson.M{"$addToSet": bson.M{"$cond": bson.A{unknown, "$action", "$$REMOVE"}}},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":             0,
+			"product_id":      "$_id.product_id",
+			"shelf_id":        "$_id.shelf_id",
+			"lot_number":      "$_id.lot_number",
+			"quantity":        1,
+			"unknown_entries": 1,
+			"unknown_actions": 1,
+		}}},
+	}
+
+	cursor, err := p.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var deltas []*StockDelta
+	if err := cursor.All(ctx, &deltas); err != nil {
+		return nil, err
+	}
+
+	return deltas, nil
+
+}
+
+// EnsureIndexes creates the per-user unique index on idempotency keys, partial
+// so entries without a key are not affected, the document number and group
+// indexes and the product and time index used to replay stock.
+func (p *productTransactionRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := p.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "action_by", Value: 1}, {Key: "idempotency_key", Value: 1}},
+			Options: options.Index().
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"idempotency_key": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "document_number", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys:    bson.D{{Key: "group_id", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		},
+		{
+			Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "created_at", Value: 1}},
+		},
+	})
+	return err
+
+}
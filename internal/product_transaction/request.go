@@ -3,6 +3,49 @@ package producttransaction
 type CreateProductTransactionRequest struct {
 	ProductID string `json:"product_id" bson:"product_id"`
 	ShelfID   string `json:"shelf_id" bson:"shelf_id"`
+	ToShelfID string `json:"to_shelf_id" bson:"to_shelf_id"`
 	Quantity  int    `json:"quantity" bson:"quantity"`
-	Action    string `json:"action" bson:"action"`
+	Action    Action `json:"action" bson:"action"`
+
+	// LotNumber is optional. On IN it receives stock into the lot, together
+	// with ExpiryDate (YYYY-MM-DD or RFC 3339); on OUT and TRANSFER it picks
+	// the lot to take from, otherwise lots are consumed first-expiring-first-out.
+	LotNumber  string `json:"lot_number" bson:"lot_number"`
+	ExpiryDate string `json:"expiry_date" bson:"expiry_date"`
+
+	// SerialNumbers is required for serialized products, one per unit.
+	SerialNumbers []string `json:"serial_numbers" bson:"serial_numbers"`
+
+	// ReservationID lets an OUT pick the stock held by that reservation.
+	ReservationID string `json:"reservation_id" bson:"reservation_id"`
+
+	// IdempotencyKey comes from the Idempotency-Key header.
+	IdempotencyKey string `json:"-" bson:"-"`
+}
+
+type GetProductTransactionsQuery struct {
+	Page       int
+	Size       int
+	ProductID  string
+	ShelfID    string
+	AncestorID string
+	Document   string
+	GroupID    string
+	LotNumber  string
+	Action     string
+	ActionBy   string
+	From       string
+	To         string
+	Sort       string
+}
+
+const (
+	BatchModeAtomic     = "atomic"
+	BatchModeBestEffort = "best_effort"
+)
+
+type BatchProductTransactionRequest struct {
+	DocumentNumber string                             `json:"document_number"`
+	Mode           string                             `json:"mode"`
+	Lines          []*CreateProductTransactionRequest `json:"lines" binding:"required"`
 }
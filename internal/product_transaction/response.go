@@ -0,0 +1,29 @@
+package producttransaction
+
+type ProductTransactionResponse struct {
+	ProductTransaction
+	ShelfPath   string `json:"shelf_path"`
+	ProductName string `json:"product_name,omitempty"`
+}
+
+type ProductTransactionListResponse struct {
+	Items      []*ProductTransactionResponse `json:"items"`
+	Total      int64                         `json:"total"`
+	Page       int                           `json:"page"`
+	Size       int                           `json:"size"`
+	TotalPages int                           `json:"total_pages"`
+}
+
+type BatchLineResult struct {
+	Line  int    `json:"line"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type BatchProductTransactionResponse struct {
+	DocumentNumber string             `json:"document_number"`
+	Mode           string             `json:"mode"`
+	Succeeded      int                `json:"succeeded"`
+	Failed         int                `json:"failed"`
+	Results        []*BatchLineResult `json:"results"`
+}
@@ -12,8 +12,11 @@ func RegisterRoutes(r *gin.Engine, handler *ProductTransactionHandler) {
 		location := api.Group("/product_transaction").Use(middleware.Secured())
 		{
 			location.POST("", handler.CreateProductTransaction)
-			// location.GET("", handler.GetProductTransactions)
-			// location.GET("/:id", handler.GetProductTransactionByID)
+			location.POST("/batch", handler.CreateBatchTransaction)
+			location.GET("", handler.GetProductTransactions)
+			location.GET("/documents/:document_number", handler.GetDocumentTransactions)
+			location.GET("/:id", handler.GetProductTransactionByID)
+			location.POST("/:id/reverse", handler.ReverseProductTransaction)
 			// location.PUT("/:id", handler.UpdateProductTransaction)
 			// location.DELETE("/:id", handler.DeleteProductTransaction)
 		}
@@ -2,8 +2,18 @@ package producttransaction
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"inventory-service/internal/product"
 	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/reservation"
+	"inventory-service/internal/serial"
+	"inventory-service/internal/storage"
+	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -12,68 +22,842 @@ import (
 
 type ProductTransactionService interface {
 	CreateProductTransaction(ctx context.Context, req *CreateProductTransactionRequest, userID string) (string, error)
+	GetProductTransactions(ctx context.Context, query *GetProductTransactionsQuery) (*ProductTransactionListResponse, error)
+	GetProductTransactionByID(ctx context.Context, id string) (*ProductTransactionResponse, error)
+	ReverseProductTransaction(ctx context.Context, id string, userID string) (string, error)
+	CreateBatchTransaction(ctx context.Context, req *BatchProductTransactionRequest, userID string) (*BatchProductTransactionResponse, error)
+	GetDocumentTransactions(ctx context.Context, documentNumber string) (*ProductTransactionListResponse, error)
 }
 
+const maxIdempotencyKeyLength = 255
+
+var (
+	// ErrAlreadyReversed reports that an entry already has a compensating entry.
+	ErrAlreadyReversed = errors.New("product transaction is already reversed")
+	// ErrIdempotencyKeyReused reports an idempotency key sent again with a
+	// different payload.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used with a different request")
+	// ErrDocumentNumberUsed reports a batch whose document number another
+	// batch already reserved.
+	ErrDocumentNumberUsed = errors.New("document number is already used")
+	// ErrSerialInStock and ErrSerialNotOnShelf report a serial number whose
+	// registered location does not allow the movement.
+	ErrSerialInStock    = errors.New("serial number is already in stock")
+	ErrSerialNotOnShelf = errors.New("serial number is not in stock on the shelf")
+)
+
 type productTransactionService struct {
 	ProductTransactionRepository ProductTransactionRepository
 	ProductPlacementService      productplacement.ProductPlacementService
+	StorageRepository            storage.StorageRepository
+	ProductService               product.ProductService
+	SerialRepository             serial.SerialRepository
+	ReservationService           reservation.ReservationService
 	mongoClient                  *mongo.Client
 }
 
 func NewProductTransactionService(
 	productTransactionRepository ProductTransactionRepository,
 	productPlacementService productplacement.ProductPlacementService,
+	storageRepository storage.StorageRepository,
+	productService product.ProductService,
+	serialRepository serial.SerialRepository,
+	reservationService reservation.ReservationService,
 	mongoClient *mongo.Client,
 ) ProductTransactionService {
 	return &productTransactionService{
 		ProductTransactionRepository: productTransactionRepository,
 		ProductPlacementService:      productPlacementService,
+		StorageRepository:            storageRepository,
+		ProductService:               productService,
+		SerialRepository:             serialRepository,
+		ReservationService:           reservationService,
 		mongoClient:                  mongoClient,
 	}
 }
 
+// CreateProductTransaction applies a transaction once per Idempotency-Key: a
+// retry with the same key and payload gets the original result back, a reuse
+// of the key with a different payload is rejected.
 func (s *productTransactionService) CreateProductTransaction(ctx context.Context, req *CreateProductTransactionRequest, userID string) (string, error) {
 
+	if req.IdempotencyKey == "" {
+		return s.createProductTransaction(ctx, req, userID, "")
+	}
+
+	if len(req.IdempotencyKey) > maxIdempotencyKeyLength {
+		return "", fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength)
+	}
+
+	requestHash := hashRequest(req)
+
+	if result, replayed, err := s.replayIdempotent(ctx, userID, req.IdempotencyKey, requestHash); err != nil || replayed {
+		return result, err
+	}
+
+	result, err := s.createProductTransaction(ctx, req, userID, requestHash)
+	if err != nil && mongo.IsDuplicateKeyError(err) {
+		// A concurrent retry with the same key committed first.
+		if result, replayed, replayErr := s.replayIdempotent(ctx, userID, req.IdempotencyKey, requestHash); replayErr != nil || replayed {
+			return result, replayErr
+		}
+	}
+
+	return result, err
+}
+
+func (s *productTransactionService) replayIdempotent(ctx context.Context, userID, key, requestHash string) (string, bool, error) {
+
+	existing, err := s.ProductTransactionRepository.GetByIdempotencyKey(ctx, userID, key)
+	if err != nil {
+		return "", false, err
+	}
+
+	if existing == nil {
+		return "", false, nil
+	}
+
+	if existing.RequestHash != requestHash {
+		return "", false, ErrIdempotencyKeyReused
+	}
+
+	return entryResult([]*ProductTransaction{existing}), true, nil
+}
+
+func hashRequest(req *CreateProductTransactionRequest) string {
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d|%s|%s|%s|%s|%s", req.ProductID, req.ShelfID, req.ToShelfID, req.Quantity, req.Action, req.LotNumber, req.ExpiryDate, strings.Join(req.SerialNumbers, ","), req.ReservationID)))
+
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *productTransactionService) createProductTransaction(ctx context.Context, req *CreateProductTransactionRequest, userID, requestHash string) (string, error) {
+
 	if userID == "" {
 		return "", fmt.Errorf("user_id is required")
 	}
 
+	entries, err := s.buildEntries(ctx, req, userID, requestHash, "")
+	if err != nil {
+		return "", err
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return "", err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		return s.applyEntries(sc, entries)
+	}
+
+	applied, err := session.WithTransaction(ctx, callback)
+	if err != nil {
+		return "", err
+	}
+
+	return entryResult(applied.([]*ProductTransaction)), nil
+
+}
+
+// buildEntries validates a request and turns it into the ledger entries to
+// write: one for IN/OUT, or a TRANSFER_OUT/TRANSFER_IN pair sharing a
+// transfer id for TRANSFER. All of them carry the first entry's id as group
+// id; only the first carries the idempotency key.
+func (s *productTransactionService) buildEntries(ctx context.Context, req *CreateProductTransactionRequest, userID, requestHash, documentNumber string) ([]*ProductTransaction, error) {
+
 	if req.ProductID == "" {
-		return "", fmt.Errorf("product_id is required")
+		return nil, fmt.Errorf("product_id is required")
 	}
 
 	if req.ShelfID == "" {
-		return "", fmt.Errorf("shelf_id is required")
+		return nil, fmt.Errorf("shelf_id is required")
 	}
 
 	if req.Quantity <= 0 {
-		return "", fmt.Errorf("quantity must be greater than 0")
+		return nil, fmt.Errorf("quantity must be greater than 0")
 	}
 
-	if req.Action == "" {
-		return "", fmt.Errorf("action is required")
+	if err := req.Action.Validate(); err != nil {
+		return nil, err
 	}
 
 	objProductID, err := primitive.ObjectIDFromHex(req.ProductID)
 	if err != nil {
-		return "", fmt.Errorf("invalid product id: %v", err)
+		return nil, fmt.Errorf("invalid product id: %v", err)
 	}
 
 	objShelfID, err := primitive.ObjectIDFromHex(req.ShelfID)
 	if err != nil {
-		return "", fmt.Errorf("invalid shelf id: %v", err)
+		return nil, fmt.Errorf("invalid shelf id: %v", err)
+	}
+
+	var toShelfID primitive.ObjectID
+	if req.Action == ActionTransfer {
+		if req.ToShelfID == "" {
+			return nil, fmt.Errorf("to_shelf_id is required")
+		}
+		toShelfID, err = primitive.ObjectIDFromHex(req.ToShelfID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid to shelf id: %v", err)
+		}
+		if toShelfID == objShelfID {
+			return nil, fmt.Errorf("to_shelf_id must differ from shelf_id")
+		}
+	}
+
+	lotNumber := strings.TrimSpace(req.LotNumber)
+	expiryDate, err := parseDate(strings.TrimSpace(req.ExpiryDate), false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expiry date: %v", err)
+	}
+	if expiryDate != nil && req.Action != ActionIn {
+		return nil, fmt.Errorf("expiry_date only applies to IN")
+	}
+	if expiryDate != nil && lotNumber == "" {
+		return nil, fmt.Errorf("expiry_date requires a lot_number")
+	}
+
+	var reservationID *primitive.ObjectID
+	if req.ReservationID != "" {
+		if req.Action != ActionOut {
+			return nil, fmt.Errorf("reservation_id only applies to OUT")
+		}
+		objReservationID, err := primitive.ObjectIDFromHex(req.ReservationID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reservation id: %v", err)
+		}
+		reservationID = &objReservationID
+	}
+
+	product, err := s.validateProduct(ctx, req.ProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	serialNumbers, err := validateSerialNumbers(product, req.SerialNumbers, req.Quantity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	groupID := primitive.NewObjectID()
+	entry := &ProductTransaction{
+		ID:             groupID,
+		GroupID:        &groupID,
+		ProductID:      objProductID,
+		ShelfID:        objShelfID,
+		Quantity:       req.Quantity,
+		Action:         req.Action,
+		LotNumber:      lotNumber,
+		ExpiryDate:     expiryDate,
+		SerialNumbers:  serialNumbers,
+		ReservationID:  reservationID,
+		DocumentNumber: documentNumber,
+		ActionBy:       userID,
+		IdempotencyKey: req.IdempotencyKey,
+		RequestHash:    requestHash,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+
+	if req.Action != ActionTransfer {
+		return []*ProductTransaction{entry}, nil
+	}
+
+	transferID := primitive.NewObjectID()
+	entry.Action = ActionTransferOut
+	entry.TransferID = &transferID
+
+	return []*ProductTransaction{
+		entry,
+		{
+			ID:             primitive.NewObjectID(),
+			ProductID:      objProductID,
+			ShelfID:        toShelfID,
+			Quantity:       req.Quantity,
+			Action:         ActionTransferIn,
+			TransferID:     &transferID,
+			GroupID:        &groupID,
+			LotNumber:      lotNumber,
+			SerialNumbers:  serialNumbers,
+			DocumentNumber: documentNumber,
+			ActionBy:       userID,
+			CreatedAt:      now,
+			UpdatedAt:      now,
+		},
+	}, nil
+}
+
+// applyEntries allocates lots to the entries, writes them and applies their
+// stock changes in order, so a transfer empties the source shelf before
+// filling the destination. Removals must leave reserved stock in place unless
+// they fulfil the reservation. It returns the entries as written.
+func (s *productTransactionService) applyEntries(sc mongo.SessionContext, entries []*ProductTransaction) ([]*ProductTransaction, error) {
+
+	for _, entry := range entries {
+		if entry.ReservationID == nil {
+			continue
+		}
+		if err := s.ReservationService.ConsumeReservation(sc, *entry.ReservationID, entry.ProductID, entry.ShelfID, entry.Quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.checkReservations(sc, entries); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.allocateLots(sc, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if err := s.ProductTransactionRepository.CreateProductTransaction(sc, entry); err != nil {
+			return nil, err
+		}
+
+		if err := s.applyPlacement(sc, entry); err != nil {
+			return nil, shelfError(entry, err)
+		}
+	}
+
+	return entries, nil
+}
+
+// checkReservations checks the removals in entries against active
+// reservations, summing removals of the same product from the same shelf. A
+// transfer only counts against the scopes its destination is outside of.
+func (s *productTransactionService) checkReservations(sc mongo.SessionContext, entries []*ProductTransaction) error {
+
+	type removal struct {
+		productID     primitive.ObjectID
+		shelfID       primitive.ObjectID
+		destinationID primitive.ObjectID
+	}
+
+	destinations := make(map[primitive.ObjectID]primitive.ObjectID)
+	for _, entry := range entries {
+		if entry.Action == ActionTransferIn && entry.TransferID != nil {
+			destinations[*entry.TransferID] = entry.ShelfID
+		}
+	}
+
+	var removals []removal
+	quantities := make(map[removal]int)
+	for _, entry := range entries {
+		if entry.Action != ActionOut && entry.Action != ActionTransferOut {
+			continue
+		}
+
+		key := removal{productID: entry.ProductID, shelfID: entry.ShelfID}
+		if entry.TransferID != nil {
+			key.destinationID = destinations[*entry.TransferID]
+		}
+		if _, ok := quantities[key]; !ok {
+			removals = append(removals, key)
+		}
+		quantities[key] += entry.Quantity
+	}
+
+	for _, key := range removals {
+		var destinationID *primitive.ObjectID
+		if !key.destinationID.IsZero() {
+			destinationID = &key.destinationID
+		}
+		if err := s.ReservationService.CheckRemoval(sc, key.productID, key.shelfID, quantities[key], destinationID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lotSplit is the part of a removal taken from one lot.
+type lotSplit struct {
+	lot           *productplacement.LotAllocation
+	serialNumbers []string
+}
+
+// allocateLots splits every OUT and TRANSFER_OUT into one entry per lot it
+// takes stock from, and gives the TRANSFER_IN side of a transfer the same
+// lots, expiry dates and serial numbers. The entries are copied so a retried
+// transaction allocates again from the original request.
+func (s *productTransactionService) allocateLots(sc mongo.SessionContext, entries []*ProductTransaction) ([]*ProductTransaction, error) {
+
+	var allocated []*ProductTransaction
+	var splits []*lotSplit
+
+	split := func(entry *ProductTransaction) {
+		for i, part := range splits {
+			copied := *entry
+			if i > 0 {
+				copied.ID = primitive.NewObjectID()
+				copied.IdempotencyKey = ""
+				copied.RequestHash = ""
+			}
+			copied.LotNumber = part.lot.LotNumber
+			copied.ExpiryDate = part.lot.ExpiryDate
+			copied.Quantity = part.lot.Quantity
+			copied.SerialNumbers = part.serialNumbers
+			allocated = append(allocated, &copied)
+		}
+	}
+
+	for _, entry := range entries {
+		switch entry.Action {
+		case ActionOut, ActionTransferOut:
+			var err error
+			splits, err = s.splitRemoval(sc, entry)
+			if err != nil {
+				return nil, shelfError(entry, err)
+			}
+			split(entry)
+		case ActionTransferIn:
+			split(entry)
+		default:
+			copied := *entry
+			allocated = append(allocated, &copied)
+		}
+	}
+
+	return allocated, nil
+}
+
+// splitRemoval picks the lots a removal takes stock from. Serialized units
+// come from the lots they were received into, anything else is the named lot
+// or first-expiring-first-out.
+func (s *productTransactionService) splitRemoval(sc mongo.SessionContext, entry *ProductTransaction) ([]*lotSplit, error) {
+
+	if len(entry.SerialNumbers) == 0 {
+		lots, err := s.ProductPlacementService.AllocateLots(sc, &productplacement.AllocateLotsRequest{
+			ProductID: entry.ProductID.Hex(),
+			ShelfID:   entry.ShelfID.Hex(),
+			LotNumber: entry.LotNumber,
+			Quantity:  entry.Quantity,
+		})
+		if err != nil {
+			return nil, err
+		}
+		splits := make([]*lotSplit, 0, len(lots))
+		for _, lot := range lots {
+			splits = append(splits, &lotSplit{lot: lot})
+		}
+		return splits, nil
+	}
+
+	units, err := s.SerialRepository.GetUnits(sc, entry.ProductID, entry.SerialNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[string]*serial.Unit, len(units))
+	for _, unit := range units {
+		byNumber[unit.SerialNumber] = unit
+	}
+
+	var splits []*lotSplit
+	byLot := make(map[string]*lotSplit)
+	for _, number := range entry.SerialNumbers {
+		unit := byNumber[number]
+		if unit == nil || unit.Status != serial.StatusInStock || unit.ShelfID == nil || *unit.ShelfID != entry.ShelfID {
+			return nil, fmt.Errorf("%w: %s", ErrSerialNotOnShelf, number)
+		}
+		if entry.LotNumber != "" && unit.LotNumber != entry.LotNumber {
+			return nil, fmt.Errorf("serial number %s is not in lot %q", number, entry.LotNumber)
+		}
+
+		part, ok := byLot[unit.LotNumber]
+		if !ok {
+			part = &lotSplit{lot: &productplacement.LotAllocation{LotNumber: unit.LotNumber}}
+			byLot[unit.LotNumber] = part
+			splits = append(splits, part)
+		}
+		part.lot.Quantity++
+		part.serialNumbers = append(part.serialNumbers, number)
+	}
+
+	for _, part := range splits {
+		if part.lot.LotNumber == "" {
+			continue
+		}
+		lots, err := s.ProductPlacementService.AllocateLots(sc, &productplacement.AllocateLotsRequest{
+			ProductID: entry.ProductID.Hex(),
+			ShelfID:   entry.ShelfID.Hex(),
+			LotNumber: part.lot.LotNumber,
+			Quantity:  part.lot.Quantity,
+		})
+		if err != nil {
+			return nil, err
+		}
+		part.lot.ExpiryDate = lots[0].ExpiryDate
+	}
+
+	return splits, nil
+}
+
+// shelfError says which side of a transfer failed.
+func shelfError(entry *ProductTransaction, err error) error {
+
+	switch entry.Action {
+	case ActionTransferOut:
+		return fmt.Errorf("source shelf: %w", err)
+	case ActionTransferIn:
+		return fmt.Errorf("destination shelf: %w", err)
+	default:
+		return err
+	}
+}
+
+// entryResult is the id returned to clients: the id of the first entry, which
+// is the TRANSFER_OUT entry for a transfer. It is also the group id, so the
+// other entries of the request can be listed by it.
+func entryResult(entries []*ProductTransaction) string {
+
+	return entries[0].ID.Hex()
+}
+
+// validateProduct checks with product-service that the product exists and is
+// active before any stock is moved.
+func (s *productTransactionService) validateProduct(ctx context.Context, productID string) (*product.Product, error) {
+
+	product, err := s.ProductService.GetProductByID(ctx, productID)
+	if err != nil {
+		return nil, fmt.Errorf("check product: %w", err)
 	}
 
-	ID := primitive.NewObjectID()
-	productTransaction := &ProductTransaction{
-		ID:        ID,
-		ProductID: objProductID,
-		ShelfID:   objShelfID,
-		Quantity:  req.Quantity,
-		Action:    req.Action,
-		ActionBy:  userID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if product == nil {
+		return nil, fmt.Errorf("product not found")
+	}
+
+	if !product.IsActive {
+		return nil, fmt.Errorf("product is not active")
+	}
+
+	return product, nil
+}
+
+// validateSerialNumbers requires one distinct serial number per unit for
+// serialized products and none for the others.
+func validateSerialNumbers(product *product.Product, serialNumbers []string, quantity int) ([]string, error) {
+
+	if !product.IsSerialized {
+		if len(serialNumbers) > 0 {
+			return nil, fmt.Errorf("product is not serialized, serial_numbers must be empty")
+		}
+		return nil, nil
+	}
+
+	if len(serialNumbers) != quantity {
+		return nil, fmt.Errorf("product is serialized, %d serial numbers are required", quantity)
+	}
+
+	seen := make(map[string]bool, len(serialNumbers))
+	normalized := make([]string, 0, len(serialNumbers))
+	for _, number := range serialNumbers {
+		number = strings.TrimSpace(number)
+		if number == "" {
+			return nil, fmt.Errorf("serial number cannot be empty")
+		}
+		if seen[number] {
+			return nil, fmt.Errorf("serial number %s is listed twice", number)
+		}
+		seen[number] = true
+		normalized = append(normalized, number)
+	}
+
+	return normalized, nil
+}
+
+const maxBatchLines = 500
+
+// CreateBatchTransaction applies the lines of one document. In atomic mode all
+// lines commit in a single transaction or none do; in best effort mode each
+// line commits on its own and failures are reported per line.
+func (s *productTransactionService) CreateBatchTransaction(ctx context.Context, req *BatchProductTransactionRequest, userID string) (*BatchProductTransactionResponse, error) {
+
+	if userID == "" {
+		return nil, fmt.Errorf("user_id is required")
+	}
+
+	if len(req.Lines) == 0 {
+		return nil, fmt.Errorf("lines is required")
+	}
+
+	if len(req.Lines) > maxBatchLines {
+		return nil, fmt.Errorf("too many lines, at most %d per batch", maxBatchLines)
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = BatchModeAtomic
+	}
+	if mode != BatchModeAtomic && mode != BatchModeBestEffort {
+		return nil, fmt.Errorf("unknown mode %q, use %s or %s", mode, BatchModeAtomic, BatchModeBestEffort)
+	}
+
+	documentNumber := strings.TrimSpace(req.DocumentNumber)
+	if documentNumber == "" {
+		documentNumber = fmt.Sprintf("DOC-%s-%s", time.Now().Format("20060102"), strings.ToUpper(primitive.NewObjectID().Hex()))
+	}
+
+	response := &BatchProductTransactionResponse{
+		DocumentNumber: documentNumber,
+		Mode:           mode,
+		Results:        make([]*BatchLineResult, 0, len(req.Lines)),
+	}
+
+	lineEntries := make([][]*ProductTransaction, len(req.Lines))
+	for i, line := range req.Lines {
+		result := &BatchLineResult{Line: i + 1}
+		response.Results = append(response.Results, result)
+
+		if line == nil {
+			result.Error = "line is empty"
+			continue
+		}
+
+		// Idempotency keys apply to whole requests, not to batch lines.
+		line.IdempotencyKey = ""
+
+		entries, err := s.buildEntries(ctx, line, userID, "", documentNumber)
+		if err != nil {
+			result.Error = err.Error()
+			continue
+		}
+		lineEntries[i] = entries
+	}
+
+	if mode == BatchModeAtomic {
+		for _, result := range response.Results {
+			if result.Error != "" {
+				response.Failed++
+			}
+		}
+		if response.Failed > 0 {
+			return response, nil
+		}
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	if mode == BatchModeAtomic {
+		applied := make([][]*ProductTransaction, len(lineEntries))
+		callback := func(sc mongo.SessionContext) (interface{}, error) {
+			if err := s.reserveDocumentNumber(sc, documentNumber, userID); err != nil {
+				return nil, err
+			}
+			for i, entries := range lineEntries {
+				written, err := s.applyEntries(sc, entries)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", i+1, err)
+				}
+				applied[i] = written
+			}
+			return nil, nil
+		}
+
+		if _, err := session.WithTransaction(ctx, callback); err != nil {
+			return nil, err
+		}
+
+		for i, result := range response.Results {
+			result.ID = entryResult(applied[i])
+		}
+		response.Succeeded = len(response.Results)
+
+		return response, nil
+	}
+
+	// Lines commit one by one, so the number is reserved up front and stays
+	// used even if every line fails.
+	if err := s.reserveDocumentNumber(ctx, documentNumber, userID); err != nil {
+		return nil, err
+	}
+
+	for i, result := range response.Results {
+		entries := lineEntries[i]
+		if entries == nil {
+			response.Failed++
+			continue
+		}
+
+		callback := func(sc mongo.SessionContext) (interface{}, error) {
+			return s.applyEntries(sc, entries)
+		}
+
+		applied, err := session.WithTransaction(ctx, callback)
+		if err != nil {
+			result.Error = err.Error()
+			response.Failed++
+			continue
+		}
+
+		result.ID = entryResult(applied.([]*ProductTransaction))
+		response.Succeeded++
+	}
+
+	return response, nil
+}
+
+// reserveDocumentNumber claims the document number for one batch. Numbers
+// used by ledger entries from before reservations were recorded count as
+// taken too.
+func (s *productTransactionService) reserveDocumentNumber(ctx context.Context, documentNumber, userID string) error {
+
+	reserved, err := s.ProductTransactionRepository.ReserveDocumentNumber(ctx, documentNumber, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !reserved {
+		return ErrDocumentNumberUsed
+	}
+
+	exists, err := s.ProductTransactionRepository.ExistsDocumentNumber(ctx, documentNumber)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrDocumentNumberUsed
+	}
+
+	return nil
+}
+
+func (s *productTransactionService) GetDocumentTransactions(ctx context.Context, documentNumber string) (*ProductTransactionListResponse, error) {
+
+	if documentNumber == "" {
+		return nil, fmt.Errorf("document_number is required")
+	}
+
+	filter := &ProductTransactionFilter{
+		DocumentNumber: documentNumber,
+		SortField:      "created_at",
+		SortOrder:      1,
+	}
+
+	transactions, total, err := s.ProductTransactionRepository.GetProductTransactions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("document not found")
+	}
+
+	items, err := s.withShelfPaths(ctx, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductTransactionListResponse{
+		Items:      items,
+		Total:      total,
+		Page:       1,
+		Size:       len(items),
+		TotalPages: 1,
+	}, nil
+}
+
+// ReverseProductTransaction undoes an entry by writing a compensating entry
+// with the inverse action and applying the inverse stock change in one
+// transaction. The whole group the entry was written with is reversed, so a
+// transfer is reversed as a pair and a removal split over lots as a whole. It
+// returns the id of the first compensating entry, which is the group id of
+// the compensation.
+func (s *productTransactionService) ReverseProductTransaction(ctx context.Context, id string, userID string) (string, error) {
+
+	if userID == "" {
+		return "", fmt.Errorf("user_id is required")
+	}
+
+	if id == "" {
+		return "", fmt.Errorf("id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return "", fmt.Errorf("invalid id: %w", err)
+	}
+
+	original, err := s.ProductTransactionRepository.GetProductTransactionByID(ctx, objectID)
+	if err != nil {
+		return "", err
+	}
+
+	if original == nil {
+		return "", fmt.Errorf("product transaction not found")
+	}
+
+	originals := []*ProductTransaction{original}
+	switch {
+	case original.GroupID != nil:
+		originals, err = s.ProductTransactionRepository.GetProductTransactionsByGroupID(ctx, *original.GroupID)
+	case original.TransferID != nil:
+		// Transfers written before group ids are linked by transfer id.
+		originals, err = s.ProductTransactionRepository.GetProductTransactionsByTransferID(ctx, *original.TransferID)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	var transferID *primitive.ObjectID
+	if original.TransferID != nil {
+		newTransferID := primitive.NewObjectID()
+		transferID = &newTransferID
+	}
+
+	// Take stock out before putting it back so a reversed transfer never
+	// needs spare capacity on the shelf it is emptying.
+	removesStock := func(entry *ProductTransaction) bool {
+		inverse := entry.Action.Inverse()
+		return inverse == ActionOut || inverse == ActionTransferOut
+	}
+	sort.SliceStable(originals, func(i, j int) bool {
+		return removesStock(originals[i]) && !removesStock(originals[j])
+	})
+
+	compensations := make([]*ProductTransaction, 0, len(originals))
+	for _, entry := range originals {
+		if entry.ReversalOf != nil {
+			return "", fmt.Errorf("a reversal cannot be reversed")
+		}
+		if entry.ReversedBy != nil {
+			return "", ErrAlreadyReversed
+		}
+		inverse := entry.Action.Inverse()
+		if inverse == "" {
+			return "", fmt.Errorf("action %q cannot be reversed", entry.Action)
+		}
+
+		reversalOf := entry.ID
+		compensations = append(compensations, &ProductTransaction{
+			ID:            primitive.NewObjectID(),
+			ProductID:     entry.ProductID,
+			ShelfID:       entry.ShelfID,
+			Quantity:      entry.Quantity,
+			Action:        inverse,
+			TransferID:    transferID,
+			LotNumber:     entry.LotNumber,
+			ExpiryDate:    entry.ExpiryDate,
+			ReversalOf:    &reversalOf,
+			SerialNumbers: entry.SerialNumbers,
+			ActionBy:      userID,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		})
+	}
+
+	groupID := compensations[0].ID
+	for _, compensation := range compensations {
+		compensation.GroupID = &groupID
 	}
 
 	session, err := s.mongoClient.StartSession()
@@ -84,37 +868,362 @@ func (s *productTransactionService) CreateProductTransaction(ctx context.Context
 
 	callback := func(sc mongo.SessionContext) (interface{}, error) {
 
-		if err := s.ProductTransactionRepository.CreateProductTransaction(sc, productTransaction); err != nil {
+		if err := s.checkReservations(sc, compensations); err != nil {
 			return nil, err
 		}
 
-		if req.Action == "IN" {
-			placementReq := &productplacement.CreateProductPlacementRequest{
-				ProductID:  req.ProductID,
-				ShelfID:    req.ShelfID,
-				CurrentQty: req.Quantity,
-			}
-			if err := s.ProductPlacementService.CreateProductPlacement(sc, placementReq); err != nil {
+		for _, compensation := range compensations {
+			marked, err := s.ProductTransactionRepository.MarkReversed(sc, *compensation.ReversalOf, compensation.ID, now)
+			if err != nil {
 				return nil, err
 			}
-		} else if req.Action == "OUT" {
-			placementReq := &productplacement.UpdateProductPlacementRequest{
-				ProductID:  req.ProductID,
-				ShelfID:    req.ShelfID,
-				CurrentQty: req.Quantity,
+			if !marked {
+				return nil, ErrAlreadyReversed
+			}
+
+			if err := s.ProductTransactionRepository.CreateProductTransaction(sc, compensation); err != nil {
+				return nil, err
 			}
-			if err := s.ProductPlacementService.UpdateProductPlacement(sc, placementReq); err != nil {
+
+			if err := s.applyPlacement(sc, compensation); err != nil {
 				return nil, err
 			}
 		}
+
 		return nil, nil
 	}
 
-	_, err = session.WithTransaction(ctx, callback)
-	if err != nil {
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
 		return "", err
 	}
 
-	return ID.Hex(), nil
+	return groupID.Hex(), nil
+}
+
+// applyPlacement applies the stock change of a single ledger entry, including
+// the registered location of its serial numbers.
+func (s *productTransactionService) applyPlacement(sc mongo.SessionContext, entry *ProductTransaction) error {
+
+	var err error
+	switch entry.Action {
+	case ActionIn, ActionTransferIn:
+		err = s.ProductPlacementService.CreateProductPlacement(sc, &productplacement.CreateProductPlacementRequest{
+			ProductID:  entry.ProductID.Hex(),
+			ShelfID:    entry.ShelfID.Hex(),
+			CurrentQty: entry.Quantity,
+			LotNumber:  entry.LotNumber,
+			ExpiryDate: entry.ExpiryDate,
+		})
+	case ActionOut, ActionTransferOut:
+		err = s.ProductPlacementService.UpdateProductPlacement(sc, &productplacement.UpdateProductPlacementRequest{
+			ProductID:  entry.ProductID.Hex(),
+			ShelfID:    entry.ShelfID.Hex(),
+			CurrentQty: entry.Quantity,
+			LotNumber:  entry.LotNumber,
+		})
+	default:
+		return fmt.Errorf("action %q does not change stock", entry.Action)
+	}
+	if err != nil {
+		return err
+	}
+
+	return s.applySerials(sc, entry)
+}
+
+func (s *productTransactionService) applySerials(sc mongo.SessionContext, entry *ProductTransaction) error {
+
+	movement := &serial.Movement{
+		TransactionID: entry.ID,
+		Action:        string(entry.Action),
+		ShelfID:       entry.ShelfID,
+		ActionBy:      entry.ActionBy,
+		ActionAt:      entry.CreatedAt,
+	}
+
+	for _, number := range entry.SerialNumbers {
+		if entry.Action == ActionIn || entry.Action == ActionTransferIn {
+			received, err := s.SerialRepository.Receive(sc, entry.ProductID, number, entry.ShelfID, entry.LotNumber, movement)
+			if err != nil {
+				return err
+			}
+			if !received {
+				return fmt.Errorf("%w: %s", ErrSerialInStock, number)
+			}
+			continue
+		}
+
+		released, err := s.SerialRepository.Release(sc, entry.ProductID, number, entry.ShelfID, movement)
+		if err != nil {
+			return err
+		}
+		if !released {
+			return fmt.Errorf("%w: %s", ErrSerialNotOnShelf, number)
+		}
+	}
+
+	return nil
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+)
+
+var productTransactionSortFields = map[string]bool{
+	"created_at": true,
+	"quantity":   true,
+	"action":     true,
+}
+
+func (s *productTransactionService) GetProductTransactions(ctx context.Context, query *GetProductTransactionsQuery) (*ProductTransactionListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	filter := &ProductTransactionFilter{
+		DocumentNumber: query.Document,
+		LotNumber:      query.LotNumber,
+		ActionBy:       query.ActionBy,
+		SortField:      "created_at",
+		SortOrder:      -1,
+		Skip:           int64((page - 1) * size),
+		Limit:          int64(size),
+	}
+
+	switch Action(query.Action) {
+	case "":
+	case ActionTransfer:
+		filter.Actions = []Action{ActionTransferOut, ActionTransferIn}
+	default:
+		filter.Actions = []Action{Action(query.Action)}
+	}
+
+	if query.ProductID != "" {
+		productID, err := primitive.ObjectIDFromHex(query.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id: %w", err)
+		}
+		filter.ProductID = &productID
+	}
+
+	if query.GroupID != "" {
+		groupID, err := primitive.ObjectIDFromHex(query.GroupID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group id: %w", err)
+		}
+		filter.GroupID = &groupID
+	}
+
+	if query.ShelfID != "" {
+		shelfID, err := primitive.ObjectIDFromHex(query.ShelfID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shelf id: %w", err)
+		}
+		filter.ShelfIDs = []primitive.ObjectID{shelfID}
+	}
+
+	if query.AncestorID != "" {
+		shelfIDs, err := s.subtreeIDs(ctx, query.AncestorID)
+		if err != nil {
+			return nil, err
+		}
+		if filter.ShelfIDs != nil {
+			shelfIDs = intersectIDs(filter.ShelfIDs, shelfIDs)
+		}
+		filter.ShelfIDs = shelfIDs
+	}
+
+	from, err := parseDate(query.From, false)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from: %w", err)
+	}
+	filter.From = from
+
+	to, err := parseDate(query.To, true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to: %w", err)
+	}
+	filter.To = to
+
+	if query.Sort != "" {
+		field := strings.TrimPrefix(query.Sort, "-")
+		if !productTransactionSortFields[field] {
+			return nil, fmt.Errorf("invalid sort field %q", field)
+		}
+		filter.SortField = field
+		filter.SortOrder = 1
+		if strings.HasPrefix(query.Sort, "-") {
+			filter.SortOrder = -1
+		}
+	}
+
+	transactions, total, err := s.ProductTransactionRepository.GetProductTransactions(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := s.withShelfPaths(ctx, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProductTransactionListResponse{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
+
+func (s *productTransactionService) GetProductTransactionByID(ctx context.Context, id string) (*ProductTransactionResponse, error) {
+
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	transaction, err := s.ProductTransactionRepository.GetProductTransactionByID(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if transaction == nil {
+		return nil, fmt.Errorf("product transaction not found")
+	}
+
+	items, err := s.withShelfPaths(ctx, []*ProductTransaction{transaction})
+	if err != nil {
+		return nil, err
+	}
+
+	response := items[0]
+
+	// The product lives in product-service; an unreachable service should not
+	// hide the transaction itself.
+	product, err := s.ProductService.GetProductByID(ctx, transaction.ProductID.Hex())
+	if err != nil {
+		log.Printf("get product %s: %v", transaction.ProductID.Hex(), err)
+	} else if product != nil {
+		response.ProductName = product.Name
+	}
+
+	return response, nil
+}
+
+func (s *productTransactionService) withShelfPaths(ctx context.Context, transactions []*ProductTransaction) ([]*ProductTransactionResponse, error) {
+
+	seen := make(map[primitive.ObjectID]bool)
+	var shelfIDs []primitive.ObjectID
+	for _, transaction := range transactions {
+		if !seen[transaction.ShelfID] {
+			seen[transaction.ShelfID] = true
+			shelfIDs = append(shelfIDs, transaction.ShelfID)
+		}
+	}
+
+	shelves, err := s.StorageRepository.GetStoragesByIDs(ctx, shelfIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[primitive.ObjectID]string, len(shelves))
+	for _, shelf := range shelves {
+		paths[shelf.ID] = shelf.Path
+	}
+
+	items := make([]*ProductTransactionResponse, 0, len(transactions))
+	for _, transaction := range transactions {
+		items = append(items, &ProductTransactionResponse{
+			ProductTransaction: *transaction,
+			ShelfPath:          paths[transaction.ShelfID],
+		})
+	}
+
+	return items, nil
+}
+
+// subtreeIDs returns the storage and every storage below it, archived ones
+// included, so history stays visible after a shelf is archived.
+func (s *productTransactionService) subtreeIDs(ctx context.Context, id string) ([]primitive.ObjectID, error) {
+
+	ancestorID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ancestor id: %w", err)
+	}
+
+	ids := []primitive.ObjectID{ancestorID}
+
+	filter := &storage.StorageFilter{
+		AncestorID:      &ancestorID,
+		IncludeArchived: true,
+		SortField:       "path",
+		SortOrder:       1,
+	}
+
+	err = s.StorageRepository.ForEachStorage(ctx, filter, func(descendant *storage.Storage) error {
+		ids = append(ids, descendant.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func intersectIDs(a, b []primitive.ObjectID) []primitive.ObjectID {
+
+	inB := make(map[primitive.ObjectID]bool, len(b))
+	for _, id := range b {
+		inB[id] = true
+	}
+
+	ids := []primitive.ObjectID{}
+	for _, id := range a {
+		if inB[id] {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
+// parseDate accepts RFC 3339 timestamps or plain dates. A plain end date
+// covers the whole day.
+func parseDate(value string, end bool) (*time.Time, error) {
+
+	if value == "" {
+		return nil, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return nil, err
+	}
+
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
 
+	return &t, nil
 }
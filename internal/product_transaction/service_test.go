@@ -0,0 +1,89 @@
+package producttransaction_test
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+
+	"inventory-service/internal/product"
+	productplacement "inventory-service/internal/product_placement"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/reservation"
+	"inventory-service/internal/serial"
+	"inventory-service/internal/shared/mongotest"
+	"inventory-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	shelfSlots  = 4
+	shelfLevels = 5
+	workers     = 40
+)
+
+func TestConcurrentTransactionsKeepShelfInvariants(t *testing.T) {
+
+	client, database := mongotest.Database(t)
+	ctx := context.Background()
+
+	storageRepository := storage.NewStorageRepository(database.Collection("storage"))
+	transactionRepository := producttransaction.NewProductTransactionRepository(database.Collection("product_transaction"), database.Collection("document"))
+	placementRepository := productplacement.NewProductPlacementRepository(database.Collection("product_placement"))
+	serialRepository := serial.NewSerialRepository(database.Collection("serial"))
+	reservationRepository := reservation.NewReservationRepository(database.Collection("reservation"), database.Collection("reservation_lock"))
+	for _, ensure := range []func(context.Context) error{
+		transactionRepository.EnsureIndexes,
+		placementRepository.EnsureIndexes,
+		serialRepository.EnsureIndexes,
+		reservationRepository.EnsureIndexes,
+	} {
+		if err := ensure(ctx); err != nil {
+			t.Fatalf("ensure indexes: %v", err)
+		}
+	}
+
+	placementService := productplacement.NewProductPlacementService(placementRepository, storageRepository, mongotest.TypeRules())
+	reservationService := reservation.NewReservationService(reservationRepository, placementRepository, storageRepository, time.Hour, client)
+	service := producttransaction.NewProductTransactionService(transactionRepository, placementService, storageRepository, product.NewStubProductService(), serialRepository, reservationService, client)
+
+	shelf := mongotest.AddShelf(t, storageRepository, shelfSlots, shelfLevels)
+	productID := primitive.NewObjectID().Hex()
+	request := func(action producttransaction.Action, qty int) *producttransaction.CreateProductTransactionRequest {
+		return &producttransaction.CreateProductTransactionRequest{ProductID: productID, ShelfID: shelf.ID.Hex(), Quantity: qty, Action: action}
+	}
+
+	// Seed the placement so every OUT finds it and can only fail on stock.
+	if _, err := service.CreateProductTransaction(ctx, request(producttransaction.ActionIn, shelfSlots), "tester"); err != nil {
+		t.Fatalf("seed: %v", err)
+	}
+
+	stop := mongotest.Watch(t, database, shelf.ID)
+	errs := mongotest.RunConcurrently(workers, func(i int) error {
+		action := producttransaction.ActionIn
+		if i%2 == 1 {
+			action = producttransaction.ActionOut
+		}
+		_, err := service.CreateProductTransaction(ctx, request(action, rand.Intn(shelfLevels)+1), "tester")
+		return err
+	})
+	stop()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+			continue
+		}
+		if !errors.Is(err, productplacement.ErrInsufficientCapacity) && !errors.Is(err, productplacement.ErrInsufficientStock) {
+			t.Errorf("losing request got %v, want ErrInsufficientCapacity or ErrInsufficientStock", err)
+		}
+	}
+	if succeeded == 0 {
+		t.Errorf("no request succeeded")
+	}
+
+	mongotest.CheckInvariant(t, database, shelf)
+}
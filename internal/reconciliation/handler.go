@@ -0,0 +1,143 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/helper"
+	"inventory-service/pkg/constants"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReconciliationHandler struct {
+	ReconciliationService ReconciliationService
+}
+
+func NewReconciliationHandler(reconciliationService ReconciliationService) *ReconciliationHandler {
+	return &ReconciliationHandler{
+		ReconciliationService: reconciliationService,
+	}
+}
+
+func (h *ReconciliationHandler) Reconcile(c *gin.Context) {
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	var req ReconcileRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+			return
+		}
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	run, err := h.ReconciliationService.Reconcile(ctx, req.Repair, userID.(string))
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Reconcile stock successfully", run)
+
+}
+
+func (h *ReconciliationHandler) GetRuns(c *gin.Context) {
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	runs, err := h.ReconciliationService.GetRuns(ctx, &GetRunsQuery{Page: page, Size: size})
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get reconciliation runs successfully", runs)
+
+}
+
+func (h *ReconciliationHandler) GetRunByID(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	run, err := h.ReconciliationService.GetRunByID(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get reconciliation run successfully", run)
+
+}
+
+func (h *ReconciliationHandler) GetRepairs(c *gin.Context) {
+
+	id := c.Param("id")
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	repairs, err := h.ReconciliationService.GetRepairs(ctx, id, &GetRepairsQuery{Page: page, Size: size})
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get reconciliation repairs successfully", repairs)
+
+}
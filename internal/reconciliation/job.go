@@ -0,0 +1,31 @@
+package reconciliation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunReconcileJob periodically reconciles the ledger with the placements and
+// logs the mismatches it finds without repairing them. It blocks until ctx is
+// cancelled.
+func RunReconcileJob(ctx context.Context, service ReconciliationService, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		run, err := service.Reconcile(ctx, false, "system")
+		if err != nil {
+			log.Printf("Failed to reconcile stock: %v", err)
+		} else if run.MismatchCount > 0 {
+			log.Printf("Reconciliation %s found %d mismatches on %d shelves", run.ID.Hex(), run.MismatchCount, run.Shelves)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package reconciliation
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	// KindPlacement compares a placement's CurrentQty with the ledger.
+	KindPlacement = "PLACEMENT"
+	// KindCapacity compares a shelf's TotalStock with its shelf type size
+	// minus the stock the ledger puts on it.
+	KindCapacity = "CAPACITY"
+	// KindUnknownAction reports ledger entries whose action is not known.
+	// They are left out of the expected stock and never repaired.
+	KindUnknownAction = "UNKNOWN_ACTION"
+)
+
+type Mismatch struct {
+	Kind      string              `json:"kind" bson:"kind"`
+	ShelfID   primitive.ObjectID  `json:"shelf_id" bson:"shelf_id"`
+	Path      string              `json:"path" bson:"path"`
+	ProductID *primitive.ObjectID `json:"product_id,omitempty" bson:"product_id,omitempty"`
+	LotNumber string              `json:"lot_number,omitempty" bson:"lot_number,omitempty"`
+	Expected  int                 `json:"expected" bson:"expected"`
+	Actual    int                 `json:"actual" bson:"actual"`
+	Repaired  bool                `json:"repaired" bson:"repaired"`
+	Note      string              `json:"note,omitempty" bson:"note,omitempty"`
+}
+
+// Repair is the audit record of one mismatch a run repaired. It is written in
+// the transaction that repairs the shelf, so every change is recorded even if
+// the run itself does not finish.
+type Repair struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	RunID      primitive.ObjectID `json:"run_id" bson:"run_id"`
+	Mismatch   `bson:",inline"`
+	RepairedAt time.Time `json:"repaired_at" bson:"repaired_at"`
+}
+
+// Run is the report of one reconciliation. It is stored when the run starts
+// and completed when it finishes. Mismatches is capped; MismatchCount is the
+// full number found, and every repaired mismatch is kept as a Repair.
+type Run struct {
+	ID            primitive.ObjectID `json:"id" bson:"_id"`
+	Repair        bool               `json:"repair" bson:"repair"`
+	Shelves       int                `json:"shelves" bson:"shelves"`
+	MismatchCount int                `json:"mismatch_count" bson:"mismatch_count"`
+	Repaired      int                `json:"repaired" bson:"repaired"`
+	Mismatches    []*Mismatch        `json:"mismatches,omitempty" bson:"mismatches"`
+	RunBy         string             `json:"run_by" bson:"run_by"`
+	StartedAt     time.Time          `json:"started_at" bson:"started_at"`
+	FinishedAt    *time.Time         `json:"finished_at,omitempty" bson:"finished_at,omitempty"`
+}
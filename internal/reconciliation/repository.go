@@ -0,0 +1,154 @@
+package reconciliation
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReconciliationRepository interface {
+	CreateRun(ctx context.Context, run *Run) error
+	FinishRun(ctx context.Context, run *Run) error
+	GetRunByID(ctx context.Context, id primitive.ObjectID) (*Run, error)
+	GetRuns(ctx context.Context, skip, limit int64) ([]*Run, int64, error)
+	CreateRepairs(ctx context.Context, repairs []*Repair) error
+	GetRepairs(ctx context.Context, runID primitive.ObjectID, skip, limit int64) ([]*Repair, int64, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+type reconciliationRepository struct {
+	collection       *mongo.Collection
+	repairCollection *mongo.Collection
+}
+
+func NewReconciliationRepository(collection, repairCollection *mongo.Collection) ReconciliationRepository {
+	return &reconciliationRepository{
+		collection:       collection,
+		repairCollection: repairCollection,
+	}
+}
+
+func (r *reconciliationRepository) CreateRun(ctx context.Context, run *Run) error {
+
+	_, err := r.collection.InsertOne(ctx, run)
+	return err
+
+}
+
+// FinishRun stores the totals and mismatches of a run created by CreateRun.
+func (r *reconciliationRepository) FinishRun(ctx context.Context, run *Run) error {
+
+	_, err := r.collection.ReplaceOne(ctx, bson.M{"_id": run.ID}, run)
+	return err
+
+}
+
+func (r *reconciliationRepository) CreateRepairs(ctx context.Context, repairs []*Repair) error {
+
+	if len(repairs) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, 0, len(repairs))
+	for _, repair := range repairs {
+		documents = append(documents, repair)
+	}
+
+	_, err := r.repairCollection.InsertMany(ctx, documents)
+	return err
+
+}
+
+// GetRepairs lists the repairs of a run in the order they were made.
+func (r *reconciliationRepository) GetRepairs(ctx context.Context, runID primitive.ObjectID, skip, limit int64) ([]*Repair, int64, error) {
+
+	filter := bson.M{"run_id": runID}
+
+	total, err := r.repairCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: 1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.repairCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	repairs := []*Repair{}
+	if err := cursor.All(ctx, &repairs); err != nil {
+		return nil, 0, err
+	}
+
+	return repairs, total, nil
+
+}
+
+func (r *reconciliationRepository) GetRunByID(ctx context.Context, id primitive.ObjectID) (*Run, error) {
+
+	var run Run
+
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&run)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &run, nil
+
+}
+
+// GetRuns lists runs newest first without their mismatches.
+func (r *reconciliationRepository) GetRuns(ctx context.Context, skip, limit int64) ([]*Run, int64, error) {
+
+	total, err := r.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "started_at", Value: -1}}).
+		SetProjection(bson.M{"mismatches": 0}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	runs := []*Run{}
+	if err := cursor.All(ctx, &runs); err != nil {
+		return nil, 0, err
+	}
+
+	return runs, total, nil
+
+}
+
+func (r *reconciliationRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "started_at", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.repairCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "run_id", Value: 1}, {Key: "_id", Value: 1}},
+	})
+	return err
+
+}
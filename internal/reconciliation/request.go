@@ -0,0 +1,15 @@
+package reconciliation
+
+type ReconcileRequest struct {
+	Repair bool `json:"repair"`
+}
+
+type GetRunsQuery struct {
+	Page int
+	Size int
+}
+
+type GetRepairsQuery struct {
+	Page int
+	Size int
+}
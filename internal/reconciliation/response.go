@@ -0,0 +1,17 @@
+package reconciliation
+
+type RunListResponse struct {
+	Items      []*Run `json:"items"`
+	Total      int64  `json:"total"`
+	Page       int    `json:"page"`
+	Size       int    `json:"size"`
+	TotalPages int    `json:"total_pages"`
+}
+
+type RepairListResponse struct {
+	Items      []*Repair `json:"items"`
+	Total      int64     `json:"total"`
+	Page       int       `json:"page"`
+	Size       int       `json:"size"`
+	TotalPages int       `json:"total_pages"`
+}
@@ -0,0 +1,21 @@
+package reconciliation
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *ReconciliationHandler) {
+
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/reconciliation").Use(middleware.Secured())
+		{
+			location.POST("", handler.Reconcile)
+			location.GET("", handler.GetRuns)
+			location.GET("/:id", handler.GetRunByID)
+			location.GET("/:id/repairs", handler.GetRepairs)
+		}
+	}
+}
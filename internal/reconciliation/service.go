@@ -0,0 +1,646 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inventory-service/internal/product"
+	productplacement "inventory-service/internal/product_placement"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/shared/model"
+	shelftype "inventory-service/internal/shelf_type"
+	"inventory-service/internal/storage"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+
+	// maxReportedMismatches keeps a run document well under the BSON size
+	// limit; MismatchCount still counts every mismatch.
+	maxReportedMismatches = 1000
+)
+
+// errPlacementChanged reports a placement that changed between reading and
+// repairing it.
+var errPlacementChanged = errors.New("placement changed during repair")
+
+type ReconciliationService interface {
+	Reconcile(ctx context.Context, repair bool, userID string) (*Run, error)
+	GetRuns(ctx context.Context, query *GetRunsQuery) (*RunListResponse, error)
+	GetRunByID(ctx context.Context, id string) (*Run, error)
+	GetRepairs(ctx context.Context, id string, query *GetRepairsQuery) (*RepairListResponse, error)
+}
+
+type reconciliationService struct {
+	repository            ReconciliationRepository
+	transactionRepository producttransaction.ProductTransactionRepository
+	placementRepository   productplacement.ProductPlacementRepository
+	storageRepository     storage.StorageRepository
+	shelfTypeRepository   shelftype.ShelfTypeRepository
+	productService        product.ProductService
+	typeRules             *storage.TypeRules
+	mongoClient           *mongo.Client
+}
+
+func NewReconciliationService(
+	repository ReconciliationRepository,
+	transactionRepository producttransaction.ProductTransactionRepository,
+	placementRepository productplacement.ProductPlacementRepository,
+	storageRepository storage.StorageRepository,
+	shelfTypeRepository shelftype.ShelfTypeRepository,
+	productService product.ProductService,
+	typeRules *storage.TypeRules,
+	mongoClient *mongo.Client,
+) ReconciliationService {
+	return &reconciliationService{
+		repository:            repository,
+		transactionRepository: transactionRepository,
+		placementRepository:   placementRepository,
+		storageRepository:     storageRepository,
+		shelfTypeRepository:   shelfTypeRepository,
+		productService:        productService,
+		typeRules:             typeRules,
+		mongoClient:           mongoClient,
+	}
+}
+
+type lotKey struct {
+	productID primitive.ObjectID
+	lotNumber string
+}
+
+// unknownEntries counts the ledger entries of a product lot whose action is
+// not known.
+type unknownEntries struct {
+	count   int
+	actions []string
+}
+
+// shelfState holds the quantity of every product lot on one shelf according
+// to the ledger (expected) and to the placements (actual), and the ledger
+// entries left out of expected because their action is unknown.
+type shelfState struct {
+	expected map[lotKey]int
+	actual   map[lotKey]int
+	unknown  map[lotKey]*unknownEntries
+}
+
+func newShelfState() *shelfState {
+	return &shelfState{expected: make(map[lotKey]int), actual: make(map[lotKey]int), unknown: make(map[lotKey]*unknownEntries)}
+}
+
+func (s *shelfState) addDelta(delta *producttransaction.StockDelta) {
+
+	key := lotKey{productID: delta.ProductID, lotNumber: delta.LotNumber}
+	s.expected[key] += delta.Quantity
+
+	if delta.UnknownEntries == 0 {
+		return
+	}
+	if _, ok := s.unknown[key]; !ok {
+		s.unknown[key] = &unknownEntries{}
+	}
+	s.unknown[key].count += delta.UnknownEntries
+	s.unknown[key].actions = append(s.unknown[key].actions, delta.UnknownActions...)
+}
+
+// Reconcile replays the whole ledger and compares it with the placements and
+// with each shelf's remaining capacity. With repair, every shelf with a
+// mismatch is checked again and fixed in its own transaction, which also
+// records its repairs and a RECONCILE ledger entry per corrected placement.
+// The run is stored before any shelf is repaired and completed at the end.
+func (s *reconciliationService) Reconcile(ctx context.Context, repair bool, userID string) (*Run, error) {
+
+	run := &Run{
+		ID:        primitive.NewObjectID(),
+		Repair:    repair,
+		RunBy:     userID,
+		StartedAt: time.Now(),
+	}
+
+	if err := s.repository.CreateRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	states := make(map[primitive.ObjectID]*shelfState)
+	state := func(shelfID primitive.ObjectID) *shelfState {
+		if _, ok := states[shelfID]; !ok {
+			states[shelfID] = newShelfState()
+		}
+		return states[shelfID]
+	}
+
+	deltas, err := s.transactionRepository.SumQuantities(ctx, &producttransaction.ProductTransactionFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, delta := range deltas {
+		state(delta.ShelfID).addDelta(delta)
+	}
+
+	err = s.placementRepository.ForEachPlacement(ctx, func(placement *productplacement.ProductPlacement) error {
+		state(placement.ShelfID).actual[lotKey{productID: placement.ProductID, lotNumber: placement.LotNumber}] += placement.CurrentQty
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	capacities, err := s.shelfTypeCapacities(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	shelves := make(map[primitive.ObjectID]*model.Storage)
+	filter := &storage.StorageFilter{SortField: "path", SortOrder: 1}
+	err = s.storageRepository.ForEachStorage(ctx, filter, func(shelf *storage.Storage) error {
+		if s.typeRules.CanHoldPlacements(shelf.Type) {
+			shelves[shelf.ID] = (*model.Storage)(shelf)
+			state(shelf.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	shelfIDs := make([]primitive.ObjectID, 0, len(states))
+	for shelfID := range states {
+		shelfIDs = append(shelfIDs, shelfID)
+	}
+	sort.Slice(shelfIDs, func(i, j int) bool {
+		return shelfPath(shelves[shelfIDs[i]]) < shelfPath(shelves[shelfIDs[j]])
+	})
+
+	for _, shelfID := range shelfIDs {
+		shelf := shelves[shelfID]
+		mismatches := compareShelf(shelfID, shelf, states[shelfID], capacity(shelf, capacities))
+
+		if repair && len(mismatches) > 0 {
+			mismatches = s.repairShelf(ctx, run, shelfID, shelf, mismatches, capacities)
+		}
+
+		run.Shelves++
+		run.MismatchCount += len(mismatches)
+		for _, mismatch := range mismatches {
+			if mismatch.Repaired {
+				run.Repaired++
+			}
+			if len(run.Mismatches) < maxReportedMismatches {
+				run.Mismatches = append(run.Mismatches, mismatch)
+			}
+		}
+	}
+
+	finishedAt := time.Now()
+	run.FinishedAt = &finishedAt
+
+	if err := s.repository.FinishRun(ctx, run); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// repairShelf reads the shelf again inside a transaction and brings its
+// placements and TotalStock in line with the ledger. It returns the
+// mismatches it found there, which replace the ones from the scan; when the
+// shelf cannot be repaired the scanned mismatches are returned with a note.
+func (s *reconciliationService) repairShelf(ctx context.Context, run *Run, shelfID primitive.ObjectID, shelf *model.Storage, scanned []*Mismatch, capacities map[primitive.ObjectID]int) []*Mismatch {
+
+	skip := func(note string) []*Mismatch {
+		for _, mismatch := range scanned {
+			if mismatch.Note == "" {
+				mismatch.Note = note
+			}
+		}
+		return scanned
+	}
+
+	if shelf == nil {
+		return skip("shelf is archived or no longer holds stock, not repaired")
+	}
+
+	// Only the products found by the scan are looked up; a placement
+	// mismatch that appears after the scan is left for the next run.
+	var productIDs []primitive.ObjectID
+	for _, mismatch := range scanned {
+		if mismatch.Kind == KindPlacement {
+			productIDs = append(productIDs, *mismatch.ProductID)
+		}
+	}
+	serialized, err := product.SerializedProducts(ctx, s.productService, productIDs)
+	if err != nil {
+		return skip(fmt.Sprintf("repair failed: %v", err))
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return skip(fmt.Sprintf("repair failed: %v", err))
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		current, err := s.storageRepository.GetStorageByID(sc, &shelfID)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil {
+			return nil, fmt.Errorf("shelf not found")
+		}
+		if current.FrozenBy != nil {
+			return nil, productplacement.ErrShelfFrozen
+		}
+
+		state := newShelfState()
+
+		deltas, err := s.transactionRepository.SumQuantities(sc, &producttransaction.ProductTransactionFilter{ShelfIDs: []primitive.ObjectID{shelfID}})
+		if err != nil {
+			return nil, err
+		}
+		for _, delta := range deltas {
+			state.addDelta(delta)
+		}
+
+		placements, err := s.placementRepository.GetProductPlacementsByShelfID(sc, shelfID)
+		if err != nil {
+			return nil, err
+		}
+		for _, placement := range placements {
+			state.actual[lotKey{productID: placement.ProductID, lotNumber: placement.LotNumber}] += placement.CurrentQty
+		}
+
+		mismatches := compareShelf(shelfID, current, state, capacity(current, capacities))
+		now := time.Now()
+
+		var repairs []*Repair
+		for _, mismatch := range mismatches {
+			// The ledger is incomplete while it has unknown actions, so
+			// nothing on the shelf is repaired from it.
+			if mismatch.Expected < 0 || len(state.unknown) > 0 {
+				continue
+			}
+			// Serialized stock is only corrected by serial number, which
+			// the ledger totals cannot tell.
+			if mismatch.Kind == KindPlacement {
+				isSerialized, checked := serialized[*mismatch.ProductID]
+				if !checked {
+					mismatch.Note = "changed since the scan, not repaired"
+					continue
+				}
+				if isSerialized {
+					mismatch.Note = "serialized product, move it by serial number, not repaired"
+					continue
+				}
+			}
+
+			switch mismatch.Kind {
+			case KindPlacement:
+				if err := s.repairPlacement(sc, run, current, mismatch, now); err != nil {
+					return nil, err
+				}
+			case KindCapacity:
+				if err := s.storageRepository.SetTotalStock(sc, shelfID, mismatch.Expected); err != nil {
+					return nil, err
+				}
+			}
+			mismatch.Repaired = true
+			repairs = append(repairs, &Repair{ID: primitive.NewObjectID(), RunID: run.ID, Mismatch: *mismatch, RepairedAt: now})
+		}
+
+		if err := s.repository.CreateRepairs(sc, repairs); err != nil {
+			return nil, err
+		}
+
+		return mismatches, nil
+	}
+
+	result, err := session.WithTransaction(ctx, callback)
+	if err != nil {
+		if errors.Is(err, productplacement.ErrShelfFrozen) {
+			return skip("shelf is frozen by a cycle count, not repaired")
+		}
+		return skip(fmt.Sprintf("repair failed: %v", err))
+	}
+
+	return result.([]*Mismatch)
+}
+
+// repairPlacement sets the placement to the ledger's quantity and records the
+// change as a RECONCILE ledger entry.
+func (s *reconciliationService) repairPlacement(sc mongo.SessionContext, run *Run, shelf *model.Storage, mismatch *Mismatch, now time.Time) error {
+
+	diff := mismatch.Expected - mismatch.Actual
+
+	err := s.transactionRepository.CreateProductTransaction(sc, &producttransaction.ProductTransaction{
+		ID:             primitive.NewObjectID(),
+		ProductID:      *mismatch.ProductID,
+		ShelfID:        shelf.ID,
+		Quantity:       diff,
+		Action:         producttransaction.ActionReconcile,
+		LotNumber:      mismatch.LotNumber,
+		DocumentNumber: "RECONCILE-" + strings.ToUpper(run.ID.Hex()),
+		ActionBy:       run.RunBy,
+		ActionAt:       now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	})
+	if err != nil {
+		return err
+	}
+
+	if diff < 0 {
+		removed, err := s.placementRepository.RemoveQuantity(sc, *mismatch.ProductID, shelf.ID, mismatch.LotNumber, -diff)
+		if err != nil {
+			return err
+		}
+		if !removed {
+			return errPlacementChanged
+		}
+		return nil
+	}
+
+	placement := &productplacement.ProductPlacement{
+		ID:          primitive.NewObjectID(),
+		ProductID:   *mismatch.ProductID,
+		ShelfID:     shelf.ID,
+		CurrentQty:  diff,
+		Path:        shelf.Path,
+		AncestorIDs: shelf.AncestorIDs,
+		LotNumber:   mismatch.LotNumber,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	if mismatch.LotNumber != "" {
+		lot, err := s.placementRepository.GetLot(sc, *mismatch.ProductID, mismatch.LotNumber)
+		if err != nil {
+			return err
+		}
+		if lot != nil {
+			placement.ExpiryDate = lot.ExpiryDate
+		}
+	}
+
+	return s.placementRepository.AddQuantity(sc, placement)
+}
+
+// shelfTypeCapacities returns slots times levels per shelf type.
+func (s *reconciliationService) shelfTypeCapacities(ctx context.Context) (map[primitive.ObjectID]int, error) {
+
+	shelfTypes, err := s.shelfTypeRepository.GetShelfTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	capacities := make(map[primitive.ObjectID]int, len(shelfTypes))
+	for _, shelfType := range shelfTypes {
+		if shelfType.Slot != nil && shelfType.Level != nil {
+			capacities[shelfType.ID] = (*shelfType.Slot) * (*shelfType.Level)
+		}
+	}
+
+	return capacities, nil
+}
+
+// capacity is the size of the shelf from its shelf type, or from its own
+// slots and levels when it has no shelf type. It is nil when unknown.
+func capacity(shelf *model.Storage, capacities map[primitive.ObjectID]int) *int {
+
+	if shelf == nil {
+		return nil
+	}
+
+	if shelf.ShelfTypeID != nil {
+		if size, ok := capacities[*shelf.ShelfTypeID]; ok {
+			return &size
+		}
+	}
+
+	if shelf.Slots != nil && shelf.Levels != nil {
+		size := (*shelf.Slots) * (*shelf.Levels)
+		return &size
+	}
+
+	return nil
+}
+
+// compareShelf lists the product lots whose placement differs from the
+// ledger, the lots with ledger entries of unknown actions and, when the shelf
+// size is known, a TotalStock that differs from the size minus the ledger's
+// stock. Mismatches on a shelf with unknown actions are noted as not
+// repairable.
+func compareShelf(shelfID primitive.ObjectID, shelf *model.Storage, state *shelfState, size *int) []*Mismatch {
+
+	mismatches := compareStock(shelfID, shelf, state, size)
+	if len(state.unknown) == 0 {
+		return mismatches
+	}
+
+	for _, mismatch := range mismatches {
+		mismatch.Note = "ledger has entries with unknown actions on the shelf, not repairable"
+	}
+
+	path := shelfPath(shelf)
+	keys := make([]lotKey, 0, len(state.unknown))
+	for key := range state.unknown {
+		keys = append(keys, key)
+	}
+	sortLotKeys(keys)
+
+	for _, key := range keys {
+		productID := key.productID
+		unknown := state.unknown[key]
+		sort.Strings(unknown.actions)
+		mismatches = append(mismatches, &Mismatch{
+			Kind:      KindUnknownAction,
+			ShelfID:   shelfID,
+			Path:      path,
+			ProductID: &productID,
+			LotNumber: key.lotNumber,
+			Expected:  state.expected[key],
+			Actual:    state.actual[key],
+			Note:      fmt.Sprintf("%d ledger entries with unknown action %s, not repairable", unknown.count, strings.Join(unknown.actions, ", ")),
+		})
+	}
+
+	return mismatches
+}
+
+func compareStock(shelfID primitive.ObjectID, shelf *model.Storage, state *shelfState, size *int) []*Mismatch {
+
+	path := shelfPath(shelf)
+
+	keys := make([]lotKey, 0, len(state.expected)+len(state.actual))
+	for key := range state.expected {
+		keys = append(keys, key)
+	}
+	for key := range state.actual {
+		if _, ok := state.expected[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sortLotKeys(keys)
+
+	var mismatches []*Mismatch
+	stocked := 0
+
+	for _, key := range keys {
+		expected := state.expected[key]
+		actual := state.actual[key]
+		if expected > 0 {
+			stocked += expected
+		}
+		if expected == actual {
+			continue
+		}
+
+		productID := key.productID
+		mismatch := &Mismatch{
+			Kind:      KindPlacement,
+			ShelfID:   shelfID,
+			Path:      path,
+			ProductID: &productID,
+			LotNumber: key.lotNumber,
+			Expected:  expected,
+			Actual:    actual,
+		}
+		if expected < 0 {
+			mismatch.Note = "ledger removes more than it added, not repairable"
+		}
+		mismatches = append(mismatches, mismatch)
+	}
+
+	if size == nil {
+		return mismatches
+	}
+
+	expected := *size - stocked
+	if shelf.TotalStock != nil && *shelf.TotalStock == expected {
+		return mismatches
+	}
+
+	mismatch := &Mismatch{
+		Kind:     KindCapacity,
+		ShelfID:  shelfID,
+		Path:     path,
+		Expected: expected,
+	}
+	if shelf.TotalStock != nil {
+		mismatch.Actual = *shelf.TotalStock
+	} else {
+		mismatch.Note = "total_stock is not set"
+	}
+	if expected < 0 {
+		mismatch.Note = "ledger puts more stock on the shelf than fits, not repairable"
+	}
+
+	return append(mismatches, mismatch)
+}
+
+func sortLotKeys(keys []lotKey) {
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].productID != keys[j].productID {
+			return keys[i].productID.Hex() < keys[j].productID.Hex()
+		}
+		return keys[i].lotNumber < keys[j].lotNumber
+	})
+}
+
+func shelfPath(shelf *model.Storage) string {
+
+	if shelf == nil {
+		return ""
+	}
+
+	return shelf.Path
+}
+
+func (s *reconciliationService) GetRuns(ctx context.Context, query *GetRunsQuery) (*RunListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	runs, total, err := s.repository.GetRuns(ctx, int64((page-1)*size), int64(size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RunListResponse{
+		Items:      runs,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
+
+func (s *reconciliationService) GetRunByID(ctx context.Context, id string) (*Run, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	run, err := s.repository.GetRunByID(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if run == nil {
+		return nil, fmt.Errorf("reconciliation run not found")
+	}
+
+	return run, nil
+}
+
+func (s *reconciliationService) GetRepairs(ctx context.Context, id string, query *GetRepairsQuery) (*RepairListResponse, error) {
+
+	run, err := s.GetRunByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	repairs, total, err := s.repository.GetRepairs(ctx, run.ID, int64((page-1)*size), int64(size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &RepairListResponse{
+		Items:      repairs,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
@@ -0,0 +1,183 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"inventory-service/helper"
+	"inventory-service/pkg/constants"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ReservationHandler struct {
+	ReservationService ReservationService
+}
+
+func NewReservationHandler(reservationService ReservationService) *ReservationHandler {
+	return &ReservationHandler{
+		ReservationService: reservationService,
+	}
+}
+
+func (h *ReservationHandler) CreateReservation(c *gin.Context) {
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	var req CreateReservationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	reservation, err := h.ReservationService.CreateReservation(ctx, &req, userID.(string))
+	if err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Create reservation successfully", reservation)
+
+}
+
+func (h *ReservationHandler) GetReservations(c *gin.Context) {
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	query := &GetReservationsQuery{
+		Page:      page,
+		Size:      size,
+		ProductID: c.Query("product_id"),
+		StorageID: c.Query("storage_id"),
+		Status:    c.Query("status"),
+		Reference: c.Query("reference"),
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	reservations, err := h.ReservationService.GetReservations(ctx, query)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get reservations successfully", reservations)
+
+}
+
+func (h *ReservationHandler) GetReservationByID(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	reservation, err := h.ReservationService.GetReservationByID(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get reservation successfully", reservation)
+
+}
+
+func (h *ReservationHandler) ReleaseReservation(c *gin.Context) {
+
+	id := c.Param("id")
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	if err := h.ReservationService.ReleaseReservation(ctx, id, userID.(string)); err != nil {
+		if isConflict(err) {
+			helper.SendError(c, 409, err, helper.ErrConflict)
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Release reservation successfully", nil)
+
+}
+
+func (h *ReservationHandler) GetAvailability(c *gin.Context) {
+
+	productID := c.Query("product_id")
+	if productID == "" {
+		helper.SendError(c, 400, fmt.Errorf("product_id is required"), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	availability, err := h.ReservationService.GetAvailability(ctx, productID, c.Query("storage_id"))
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get availability successfully", availability)
+
+}
+
+func isConflict(err error) bool {
+	return errors.Is(err, ErrNotAvailable) ||
+		errors.Is(err, ErrReservationClosed)
+}
@@ -0,0 +1,30 @@
+package reservation
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunExpiryJob periodically marks active reservations past their expiry as
+// expired so they stop holding stock. It blocks until ctx is cancelled.
+func RunExpiryJob(ctx context.Context, service ReservationService, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		expired, err := service.ExpireReservations(ctx, time.Now())
+		if err != nil {
+			log.Printf("Failed to expire reservations: %v", err)
+		} else if expired > 0 {
+			log.Printf("Expired %d reservations", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
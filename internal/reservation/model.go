@@ -0,0 +1,35 @@
+package reservation
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StatusActive    = "ACTIVE"
+	StatusReleased  = "RELEASED"
+	StatusExpired   = "EXPIRED"
+	StatusFulfilled = "FULFILLED"
+)
+
+// Reservation holds Quantity of a product for an order. StorageID scopes it
+// to a shelf or to anything below a storage; without it the stock is held
+// anywhere. Quantity shrinks as OUT transactions fulfil the reservation.
+type Reservation struct {
+	ID          primitive.ObjectID   `json:"id" bson:"_id"`
+	ProductID   primitive.ObjectID   `json:"product_id" bson:"product_id"`
+	StorageID   *primitive.ObjectID  `json:"storage_id,omitempty" bson:"storage_id,omitempty"`
+	Path        string               `json:"path,omitempty" bson:"path,omitempty"`
+	AncestorIDs []primitive.ObjectID `json:"ancestor_ids,omitempty" bson:"ancestor_ids,omitempty"`
+	Quantity    int                  `json:"quantity" bson:"quantity"`
+	ReservedQty int                  `json:"reserved_qty" bson:"reserved_qty"`
+	Reference   string               `json:"reference,omitempty" bson:"reference,omitempty"`
+	Status      string               `json:"status" bson:"status"`
+	ExpiresAt   time.Time            `json:"expires_at" bson:"expires_at"`
+	CreatedBy   string               `json:"created_by" bson:"created_by"`
+	ClosedBy    string               `json:"closed_by,omitempty" bson:"closed_by,omitempty"`
+	ClosedAt    *time.Time           `json:"closed_at,omitempty" bson:"closed_at,omitempty"`
+	CreatedAt   time.Time            `json:"created_at" bson:"created_at"`
+	UpdatedAt   time.Time            `json:"updated_at" bson:"updated_at"`
+}
@@ -0,0 +1,253 @@
+package reservation
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type ReservationFilter struct {
+	ProductID *primitive.ObjectID
+	StorageID *primitive.ObjectID
+	Status    string
+	Reference string
+	Skip      int64
+	Limit     int64
+}
+
+type ReservationRepository interface {
+	CreateReservation(ctx context.Context, reservation *Reservation) error
+	GetReservationByID(ctx context.Context, id primitive.ObjectID) (*Reservation, error)
+	GetReservations(ctx context.Context, filter *ReservationFilter) ([]*Reservation, int64, error)
+	SumReserved(ctx context.Context, productID primitive.ObjectID, storageID *primitive.ObjectID, now time.Time) (int, error)
+	CountActive(ctx context.Context, productID primitive.ObjectID, now time.Time) (int64, error)
+	UpdateQuantity(ctx context.Context, id primitive.ObjectID, from, to int, now time.Time) (bool, error)
+	CloseReservation(ctx context.Context, id primitive.ObjectID, status, userID string, now time.Time) (bool, error)
+	ExpireReservations(ctx context.Context, now time.Time) (int64, error)
+	Lock(ctx context.Context, productID primitive.ObjectID) error
+	EnsureIndexes(ctx context.Context) error
+}
+
+type reservationRepository struct {
+	collection     *mongo.Collection
+	lockCollection *mongo.Collection
+}
+
+func NewReservationRepository(collection, lockCollection *mongo.Collection) ReservationRepository {
+	return &reservationRepository{
+		collection:     collection,
+		lockCollection: lockCollection,
+	}
+}
+
+// activeFilter matches reservations still holding stock at now. Reservations
+// past their expiry stop counting before the expiry job marks them.
+func activeFilter(productID primitive.ObjectID, now time.Time) bson.M {
+	return bson.M{
+		"product_id": productID,
+		"status":     StatusActive,
+		"expires_at": bson.M{"$gt": now},
+	}
+}
+
+func (r *reservationRepository) CreateReservation(ctx context.Context, reservation *Reservation) error {
+
+	_, err := r.collection.InsertOne(ctx, reservation)
+	return err
+
+}
+
+func (r *reservationRepository) GetReservationByID(ctx context.Context, id primitive.ObjectID) (*Reservation, error) {
+
+	var reservation Reservation
+
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&reservation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &reservation, nil
+
+}
+
+func (r *reservationRepository) GetReservations(ctx context.Context, filter *ReservationFilter) ([]*Reservation, int64, error) {
+
+	query := bson.M{}
+	if filter.ProductID != nil {
+		query["product_id"] = filter.ProductID
+	}
+	if filter.StorageID != nil {
+		query["$or"] = bson.A{
+			bson.M{"storage_id": filter.StorageID},
+			bson.M{"ancestor_ids": filter.StorageID},
+		}
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	if filter.Reference != "" {
+		query["reference"] = filter.Reference
+	}
+
+	total, err := r.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(filter.Skip).
+		SetLimit(filter.Limit)
+
+	cursor, err := r.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	reservations := []*Reservation{}
+	if err := cursor.All(ctx, &reservations); err != nil {
+		return nil, 0, err
+	}
+
+	return reservations, total, nil
+
+}
+
+// SumReserved returns the quantity held by active reservations scoped to the
+// storage or below it. A nil storageID sums every active reservation.
+func (r *reservationRepository) SumReserved(ctx context.Context, productID primitive.ObjectID, storageID *primitive.ObjectID, now time.Time) (int, error) {
+
+	match := activeFilter(productID, now)
+	if storageID != nil {
+		match["$or"] = bson.A{
+			bson.M{"storage_id": storageID},
+			bson.M{"ancestor_ids": storageID},
+		}
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"total": bson.M{"$sum": "$quantity"},
+		}}},
+	}
+
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var rows []struct {
+		Total int `bson:"total"`
+	}
+	if err := cursor.All(ctx, &rows); err != nil {
+		return 0, err
+	}
+
+	if len(rows) == 0 {
+		return 0, nil
+	}
+
+	return rows[0].Total, nil
+
+}
+
+func (r *reservationRepository) CountActive(ctx context.Context, productID primitive.ObjectID, now time.Time) (int64, error) {
+	return r.collection.CountDocuments(ctx, activeFilter(productID, now))
+}
+
+// UpdateQuantity sets the remaining quantity of an active reservation from
+// from to to, fulfilling it at zero. It reports false when the reservation
+// changed in between.
+func (r *reservationRepository) UpdateQuantity(ctx context.Context, id primitive.ObjectID, from, to int, now time.Time) (bool, error) {
+
+	set := bson.M{"quantity": to, "updated_at": now}
+	if to == 0 {
+		set["status"] = StatusFulfilled
+		set["closed_at"] = now
+	}
+
+	filter := bson.M{"_id": id, "status": StatusActive, "quantity": from}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": set})
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+
+}
+
+// CloseReservation moves an active reservation to status. It reports false
+// when the reservation is no longer active.
+func (r *reservationRepository) CloseReservation(ctx context.Context, id primitive.ObjectID, status, userID string, now time.Time) (bool, error) {
+
+	filter := bson.M{"_id": id, "status": StatusActive}
+	update := bson.M{"$set": bson.M{
+		"status":     status,
+		"closed_by":  userID,
+		"closed_at":  now,
+		"updated_at": now,
+	}}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+
+}
+
+func (r *reservationRepository) ExpireReservations(ctx context.Context, now time.Time) (int64, error) {
+
+	filter := bson.M{"status": StatusActive, "expires_at": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{
+		"status":     StatusExpired,
+		"closed_at":  now,
+		"updated_at": now,
+	}}
+
+	result, err := r.collection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+
+}
+
+// Lock writes the product's lock document inside the caller's transaction.
+// Transactions that check availability of the same product then conflict
+// and retry instead of both passing the check on stale reads.
+func (r *reservationRepository) Lock(ctx context.Context, productID primitive.ObjectID) error {
+
+	_, err := r.lockCollection.UpdateOne(ctx,
+		bson.M{"_id": productID},
+		bson.M{"$inc": bson.M{"version": 1}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+
+}
+
+func (r *reservationRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "product_id", Value: 1}, {Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "expires_at", Value: 1}}},
+		{Keys: bson.D{{Key: "reference", Value: 1}}, Options: options.Index().SetSparse(true)},
+	})
+	return err
+
+}
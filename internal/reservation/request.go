@@ -0,0 +1,21 @@
+package reservation
+
+// CreateReservationRequest sets the expiry either as a timestamp or as a
+// number of seconds from now; with neither the configured default applies.
+type CreateReservationRequest struct {
+	ProductID  string `json:"product_id" binding:"required"`
+	StorageID  string `json:"storage_id"`
+	Quantity   int    `json:"quantity" binding:"required"`
+	Reference  string `json:"reference"`
+	ExpiresAt  string `json:"expires_at"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+type GetReservationsQuery struct {
+	Page      int
+	Size      int
+	ProductID string
+	StorageID string
+	Status    string
+	Reference string
+}
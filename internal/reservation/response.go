@@ -0,0 +1,21 @@
+package reservation
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type ReservationListResponse struct {
+	Items      []*Reservation `json:"items"`
+	Total      int64          `json:"total"`
+	Page       int            `json:"page"`
+	Size       int            `json:"size"`
+	TotalPages int            `json:"total_pages"`
+}
+
+// AvailabilityResponse is the available-to-promise quantity of a product in
+// a storage subtree, or everywhere when StorageID is empty.
+type AvailabilityResponse struct {
+	ProductID primitive.ObjectID  `json:"product_id"`
+	StorageID *primitive.ObjectID `json:"storage_id,omitempty"`
+	OnHand    int                 `json:"on_hand"`
+	Reserved  int                 `json:"reserved"`
+	Available int                 `json:"available"`
+}
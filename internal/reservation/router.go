@@ -0,0 +1,22 @@
+package reservation
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *ReservationHandler) {
+
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/reservation").Use(middleware.Secured())
+		{
+			location.POST("", handler.CreateReservation)
+			location.GET("", handler.GetReservations)
+			location.GET("/availability", handler.GetAvailability)
+			location.GET("/:id", handler.GetReservationByID)
+			location.POST("/:id/release", handler.ReleaseReservation)
+		}
+	}
+}
@@ -0,0 +1,449 @@
+package reservation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/shared/model"
+	"inventory-service/internal/storage"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+)
+
+var (
+	// ErrNotAvailable reports stock that is on hand but held by reservations.
+	ErrNotAvailable = errors.New("not enough unreserved stock")
+	// ErrReservationClosed reports a reservation that was already released,
+	// fulfilled or expired.
+	ErrReservationClosed = errors.New("reservation is not active")
+)
+
+type ReservationService interface {
+	CreateReservation(ctx context.Context, req *CreateReservationRequest, userID string) (*Reservation, error)
+	GetReservations(ctx context.Context, query *GetReservationsQuery) (*ReservationListResponse, error)
+	GetReservationByID(ctx context.Context, id string) (*Reservation, error)
+	ReleaseReservation(ctx context.Context, id string, userID string) error
+	GetAvailability(ctx context.Context, productID, storageID string) (*AvailabilityResponse, error)
+	ExpireReservations(ctx context.Context, now time.Time) (int64, error)
+
+	// ConsumeReservation and CheckRemoval run inside a product transaction.
+	ConsumeReservation(sc mongo.SessionContext, id, productID, shelfID primitive.ObjectID, qty int) error
+	CheckRemoval(sc mongo.SessionContext, productID, shelfID primitive.ObjectID, qty int, destinationID *primitive.ObjectID) error
+}
+
+type reservationService struct {
+	repository          ReservationRepository
+	placementRepository productplacement.ProductPlacementRepository
+	storageRepository   storage.StorageRepository
+	defaultTTL          time.Duration
+	mongoClient         *mongo.Client
+}
+
+func NewReservationService(
+	repository ReservationRepository,
+	placementRepository productplacement.ProductPlacementRepository,
+	storageRepository storage.StorageRepository,
+	defaultTTL time.Duration,
+	mongoClient *mongo.Client,
+) ReservationService {
+	return &reservationService{
+		repository:          repository,
+		placementRepository: placementRepository,
+		storageRepository:   storageRepository,
+		defaultTTL:          defaultTTL,
+		mongoClient:         mongoClient,
+	}
+}
+
+// scopeChain lists the scopes a storage belongs to, from everywhere (nil)
+// down to the storage itself.
+func scopeChain(storage *model.Storage) []*primitive.ObjectID {
+
+	scopes := []*primitive.ObjectID{nil}
+	for i := range storage.AncestorIDs {
+		scopes = append(scopes, &storage.AncestorIDs[i])
+	}
+
+	id := storage.ID
+	return append(scopes, &id)
+}
+
+func scopeKey(scope *primitive.ObjectID) string {
+
+	if scope == nil {
+		return ""
+	}
+
+	return scope.Hex()
+}
+
+// available returns on-hand minus reserved stock of the product in scope.
+func (s *reservationService) available(ctx context.Context, productID primitive.ObjectID, scope *primitive.ObjectID, now time.Time) (int, int, error) {
+
+	onHand, err := s.placementRepository.SumProductQuantity(ctx, productID, scope)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	reserved, err := s.repository.SumReserved(ctx, productID, scope, now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return onHand, reserved, nil
+}
+
+func (s *reservationService) CreateReservation(ctx context.Context, req *CreateReservationRequest, userID string) (*Reservation, error) {
+
+	if req.Quantity <= 0 {
+		return nil, fmt.Errorf("quantity must be greater than 0")
+	}
+
+	productID, err := primitive.ObjectIDFromHex(req.ProductID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid product id: %w", err)
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.defaultTTL)
+	switch {
+	case req.ExpiresAt != "" && req.TTLSeconds != 0:
+		return nil, fmt.Errorf("set either expires_at or ttl_seconds")
+	case req.ExpiresAt != "":
+		expiresAt, err = time.Parse(time.RFC3339, req.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expires_at: %w", err)
+		}
+	case req.TTLSeconds < 0:
+		return nil, fmt.Errorf("ttl_seconds must be greater than 0")
+	case req.TTLSeconds > 0:
+		expiresAt = now.Add(time.Duration(req.TTLSeconds) * time.Second)
+	}
+	if !expiresAt.After(now) {
+		return nil, fmt.Errorf("expires_at must be in the future")
+	}
+
+	reservation := &Reservation{
+		ID:          primitive.NewObjectID(),
+		ProductID:   productID,
+		Quantity:    req.Quantity,
+		ReservedQty: req.Quantity,
+		Reference:   strings.TrimSpace(req.Reference),
+		Status:      StatusActive,
+		ExpiresAt:   expiresAt,
+		CreatedBy:   userID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	scopes := []*primitive.ObjectID{nil}
+	if req.StorageID != "" {
+		storageID, err := primitive.ObjectIDFromHex(req.StorageID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage id: %w", err)
+		}
+
+		storage, err := s.storageRepository.GetStorageByID(ctx, &storageID)
+		if err != nil {
+			return nil, err
+		}
+		if storage == nil {
+			return nil, fmt.Errorf("storage not found")
+		}
+
+		reservation.StorageID = &storage.ID
+		reservation.Path = storage.Path
+		reservation.AncestorIDs = storage.AncestorIDs
+		scopes = scopeChain(storage)
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	// The reservation also uses up availability of every scope above its
+	// own, so each one must still have enough unreserved stock.
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		if err := s.repository.Lock(sc, productID); err != nil {
+			return nil, err
+		}
+
+		for _, scope := range scopes {
+			onHand, reserved, err := s.available(sc, productID, scope, now)
+			if err != nil {
+				return nil, err
+			}
+			if onHand-reserved < req.Quantity {
+				return nil, fmt.Errorf("%w: %d available", ErrNotAvailable, onHand-reserved)
+			}
+		}
+
+		return nil, s.repository.CreateReservation(sc, reservation)
+	}
+
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+func (s *reservationService) GetReservations(ctx context.Context, query *GetReservationsQuery) (*ReservationListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	filter := &ReservationFilter{
+		Status:    query.Status,
+		Reference: query.Reference,
+		Skip:      int64((page - 1) * size),
+		Limit:     int64(size),
+	}
+
+	if query.ProductID != "" {
+		productID, err := primitive.ObjectIDFromHex(query.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id: %w", err)
+		}
+		filter.ProductID = &productID
+	}
+
+	if query.StorageID != "" {
+		storageID, err := primitive.ObjectIDFromHex(query.StorageID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage id: %w", err)
+		}
+		filter.StorageID = &storageID
+	}
+
+	reservations, total, err := s.repository.GetReservations(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReservationListResponse{
+		Items:      reservations,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
+
+func (s *reservationService) GetReservationByID(ctx context.Context, id string) (*Reservation, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	reservation, err := s.repository.GetReservationByID(ctx, objectID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation == nil {
+		return nil, fmt.Errorf("reservation not found")
+	}
+
+	return reservation, nil
+}
+
+func (s *reservationService) ReleaseReservation(ctx context.Context, id string, userID string) error {
+
+	reservation, err := s.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	released, err := s.repository.CloseReservation(ctx, reservation.ID, StatusReleased, userID, time.Now())
+	if err != nil {
+		return err
+	}
+	if !released {
+		return ErrReservationClosed
+	}
+
+	return nil
+}
+
+func (s *reservationService) GetAvailability(ctx context.Context, productID, storageID string) (*AvailabilityResponse, error) {
+
+	objProductID, err := primitive.ObjectIDFromHex(productID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid product id: %w", err)
+	}
+
+	response := &AvailabilityResponse{ProductID: objProductID}
+
+	if storageID != "" {
+		objStorageID, err := primitive.ObjectIDFromHex(storageID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage id: %w", err)
+		}
+		response.StorageID = &objStorageID
+	}
+
+	response.OnHand, response.Reserved, err = s.available(ctx, objProductID, response.StorageID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	response.Available = response.OnHand - response.Reserved
+	if response.Available < 0 {
+		response.Available = 0
+	}
+
+	return response, nil
+}
+
+func (s *reservationService) ExpireReservations(ctx context.Context, now time.Time) (int64, error) {
+	return s.repository.ExpireReservations(ctx, now)
+}
+
+// ConsumeReservation takes qty off the reservation an OUT transaction picks
+// for, so the reservation stops holding the stock being removed. Picking more
+// than is reserved fulfils the reservation. A reservation scoped to a storage
+// can only be picked from a shelf inside it.
+func (s *reservationService) ConsumeReservation(sc mongo.SessionContext, id, productID, shelfID primitive.ObjectID, qty int) error {
+
+	reservation, err := s.repository.GetReservationByID(sc, id)
+	if err != nil {
+		return err
+	}
+	if reservation == nil {
+		return fmt.Errorf("reservation not found")
+	}
+	if reservation.ProductID != productID {
+		return fmt.Errorf("reservation is for another product")
+	}
+
+	if reservation.StorageID != nil {
+		shelf, err := s.storageRepository.GetStorageByID(sc, &shelfID)
+		if err != nil {
+			return err
+		}
+		if shelf == nil {
+			return fmt.Errorf("shelf not found")
+		}
+		if !inScope(shelf, *reservation.StorageID) {
+			return fmt.Errorf("reservation is for stock in another storage")
+		}
+	}
+
+	now := time.Now()
+	if reservation.Status != StatusActive || !reservation.ExpiresAt.After(now) {
+		return ErrReservationClosed
+	}
+
+	remaining := reservation.Quantity - qty
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	updated, err := s.repository.UpdateQuantity(sc, reservation.ID, reservation.Quantity, remaining, now)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrReservationClosed
+	}
+
+	return nil
+}
+
+// inScope reports whether storage is scopeID or lies under it.
+func inScope(storage *model.Storage, scopeID primitive.ObjectID) bool {
+
+	if storage.ID == scopeID {
+		return true
+	}
+
+	for _, ancestorID := range storage.AncestorIDs {
+		if ancestorID == scopeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckRemoval rejects taking qty of the product off the shelf when that
+// would leave any scope holding the shelf with less stock than is reserved
+// in it. Scopes that also hold destinationID are skipped, since a transfer
+// within them leaves their stock unchanged. The product lock is only taken
+// when it has active reservations, so removals of unreserved products do not
+// queue behind each other; a first reservation racing such a removal is not
+// caught.
+func (s *reservationService) CheckRemoval(sc mongo.SessionContext, productID, shelfID primitive.ObjectID, qty int, destinationID *primitive.ObjectID) error {
+
+	now := time.Now()
+	active, err := s.repository.CountActive(sc, productID, now)
+	if err != nil {
+		return err
+	}
+	if active == 0 {
+		return nil
+	}
+
+	if err := s.repository.Lock(sc, productID); err != nil {
+		return err
+	}
+
+	shelf, err := s.storageRepository.GetStorageByID(sc, &shelfID)
+	if err != nil {
+		return err
+	}
+	if shelf == nil {
+		return fmt.Errorf("shelf not found")
+	}
+
+	shared := make(map[string]bool)
+	if destinationID != nil {
+		destination, err := s.storageRepository.GetStorageByID(sc, destinationID)
+		if err != nil {
+			return err
+		}
+		if destination != nil {
+			for _, scope := range scopeChain(destination) {
+				shared[scopeKey(scope)] = true
+			}
+		}
+	}
+
+	for _, scope := range scopeChain(shelf) {
+		if shared[scopeKey(scope)] {
+			continue
+		}
+
+		onHand, reserved, err := s.available(sc, productID, scope, now)
+		if err != nil {
+			return err
+		}
+		if onHand-qty < reserved {
+			return fmt.Errorf("%w: %d reserved, %d on hand", ErrNotAvailable, reserved, onHand)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/helper"
+	"inventory-service/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScanHandler struct {
+	ScanService ScanService
+}
+
+func NewScanHandler(scanService ScanService) *ScanHandler {
+	return &ScanHandler{
+		ScanService: scanService,
+	}
+}
+
+func (h *ScanHandler) Scan(c *gin.Context) {
+
+	var req ScanRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	result, err := h.ScanService.Scan(ctx, req.Code)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Scan successfully", result)
+
+}
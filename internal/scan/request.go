@@ -0,0 +1,5 @@
+package scan
+
+type ScanRequest struct {
+	Code string `json:"code" binding:"required"`
+}
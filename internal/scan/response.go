@@ -0,0 +1,24 @@
+package scan
+
+import (
+	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/shared/model"
+	shelfquantity "inventory-service/internal/shelf_quantity"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type BreadcrumbItem struct {
+	ID   primitive.ObjectID `json:"id"`
+	Name string             `json:"name"`
+	Type string             `json:"type"`
+}
+
+type ScanResponse struct {
+	EntityType    string                               `json:"entity_type"`
+	Storage       *model.Storage                       `json:"storage"`
+	ShelfQuantity *shelfquantity.ShelfQuantity         `json:"shelf_quantity,omitempty"`
+	Breadcrumb    []*BreadcrumbItem                    `json:"breadcrumb"`
+	Placements    []*productplacement.ProductPlacement `json:"placements"`
+	Actions       []string                             `json:"actions"`
+}
@@ -0,0 +1,17 @@
+package scan
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *ScanHandler) {
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/scan").Use(middleware.Secured())
+		{
+			location.POST("", handler.Scan)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package scan
+
+import (
+	"context"
+	"fmt"
+	productplacement "inventory-service/internal/product_placement"
+	"inventory-service/internal/shared/model"
+	shelfquantity "inventory-service/internal/shelf_quantity"
+	"inventory-service/internal/storage"
+	"inventory-service/pkg/constants"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	EntityStorage       = "storage"
+	EntityShelfQuantity = "shelf_quantity"
+
+	ActionIn       = "IN"
+	ActionOut      = "OUT"
+	ActionTransfer = "TRANSFER"
+	ActionMove     = "MOVE"
+)
+
+type ScanService interface {
+	Scan(ctx context.Context, code string) (*ScanResponse, error)
+}
+
+type scanService struct {
+	storageRepository       storage.StorageRepository
+	shelfQuantityRepository shelfquantity.ShelfQuantityRepository
+	placementRepository     productplacement.ProductPlacementRepository
+	typeRules               *storage.TypeRules
+}
+
+func NewScanService(
+	storageRepository storage.StorageRepository,
+	shelfQuantityRepository shelfquantity.ShelfQuantityRepository,
+	placementRepository productplacement.ProductPlacementRepository,
+	typeRules *storage.TypeRules,
+) ScanService {
+	return &scanService{
+		storageRepository:       storageRepository,
+		shelfQuantityRepository: shelfQuantityRepository,
+		placementRepository:     placementRepository,
+		typeRules:               typeRules,
+	}
+}
+
+func (s *scanService) Scan(ctx context.Context, code string) (*ScanResponse, error) {
+
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+
+	switch {
+	case strings.HasPrefix(code, constants.StorageQRPrefix):
+		return s.scanStorage(ctx, strings.TrimPrefix(code, constants.StorageQRPrefix))
+	case strings.HasPrefix(code, constants.InventoryQRPrefix):
+		return s.scanShelfQuantity(ctx, strings.TrimPrefix(code, constants.InventoryQRPrefix))
+	default:
+		return nil, fmt.Errorf("unrecognized code %q", code)
+	}
+}
+
+func (s *scanService) scanStorage(ctx context.Context, id string) (*ScanResponse, error) {
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid storage id: %w", err)
+	}
+
+	storage, err := s.storageRepository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
+
+	return s.buildResponse(ctx, EntityStorage, storage, nil)
+}
+
+func (s *scanService) scanShelfQuantity(ctx context.Context, code string) (*ScanResponse, error) {
+
+	if code == "" {
+		return nil, fmt.Errorf("inventory code is required")
+	}
+
+	shelfQuantity, err := s.shelfQuantityRepository.GetShelfQuantityByCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	if shelfQuantity == nil {
+		return nil, fmt.Errorf("inventory code not found")
+	}
+
+	storage, err := s.storageRepository.GetStorageByID(ctx, &shelfQuantity.ShelfID)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		return nil, fmt.Errorf("shelf not found")
+	}
+
+	return s.buildResponse(ctx, EntityShelfQuantity, storage, shelfQuantity)
+}
+
+func (s *scanService) buildResponse(ctx context.Context, entityType string, storage *model.Storage, shelfQuantity *shelfquantity.ShelfQuantity) (*ScanResponse, error) {
+
+	breadcrumb, err := s.breadcrumb(ctx, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	placements, err := s.placementRepository.GetProductPlacementsByShelfID(ctx, storage.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if placements == nil {
+		placements = []*productplacement.ProductPlacement{}
+	}
+
+	return &ScanResponse{
+		EntityType:    entityType,
+		Storage:       storage,
+		ShelfQuantity: shelfQuantity,
+		Breadcrumb:    breadcrumb,
+		Placements:    placements,
+		Actions:       s.allowedActions(storage, placements),
+	}, nil
+}
+
+func (s *scanService) breadcrumb(ctx context.Context, storage *model.Storage) ([]*BreadcrumbItem, error) {
+
+	ancestors, err := s.storageRepository.GetStoragesByIDs(ctx, storage.AncestorIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byID := make(map[primitive.ObjectID]*model.Storage, len(ancestors))
+	for _, ancestor := range ancestors {
+		byID[ancestor.ID] = ancestor
+	}
+
+	breadcrumb := make([]*BreadcrumbItem, 0, len(storage.AncestorIDs)+1)
+	for _, id := range storage.AncestorIDs {
+		if ancestor, ok := byID[id]; ok {
+			breadcrumb = append(breadcrumb, &BreadcrumbItem{ID: ancestor.ID, Name: ancestor.Name, Type: ancestor.Type})
+		}
+	}
+
+	breadcrumb = append(breadcrumb, &BreadcrumbItem{ID: storage.ID, Name: storage.Name, Type: storage.Type})
+
+	return breadcrumb, nil
+}
+
+func (s *scanService) allowedActions(storage *model.Storage, placements []*productplacement.ProductPlacement) []string {
+
+	actions := []string{}
+
+	if s.typeRules.CanHoldPlacements(storage.Type) {
+		actions = append(actions, ActionIn)
+
+		for _, placement := range placements {
+			if placement.CurrentQty > 0 {
+				actions = append(actions, ActionOut, ActionTransfer)
+				break
+			}
+		}
+	}
+
+	actions = append(actions, ActionMove)
+
+	return actions
+}
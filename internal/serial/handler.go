@@ -0,0 +1,42 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/helper"
+	"inventory-service/pkg/constants"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SerialHandler struct {
+	SerialService SerialService
+}
+
+func NewSerialHandler(serialService SerialService) *SerialHandler {
+	return &SerialHandler{
+		SerialService: serialService,
+	}
+}
+
+func (h *SerialHandler) GetBySerialNumber(c *gin.Context) {
+
+	serialNumber := c.Param("serial_number")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	units, err := h.SerialService.GetBySerialNumber(ctx, serialNumber, c.Query("product_id"))
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get serial number successfully", units)
+
+}
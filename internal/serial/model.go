@@ -0,0 +1,34 @@
+package serial
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	StatusInStock = "IN_STOCK"
+	StatusOut     = "OUT"
+)
+
+// Unit is one serialized item. ShelfID is set while the unit is in stock.
+type Unit struct {
+	ID           primitive.ObjectID  `json:"id" bson:"_id"`
+	ProductID    primitive.ObjectID  `json:"product_id" bson:"product_id"`
+	SerialNumber string              `json:"serial_number" bson:"serial_number"`
+	Status       string              `json:"status" bson:"status"`
+	ShelfID      *primitive.ObjectID `json:"shelf_id,omitempty" bson:"shelf_id,omitempty"`
+	LotNumber    string              `json:"lot_number,omitempty" bson:"lot_number,omitempty"`
+	Movements    []*Movement         `json:"movements" bson:"movements"`
+	CreatedAt    time.Time           `json:"created_at" bson:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at" bson:"updated_at"`
+}
+
+// Movement records a ledger entry that moved the unit.
+type Movement struct {
+	TransactionID primitive.ObjectID `json:"transaction_id" bson:"transaction_id"`
+	Action        string             `json:"action" bson:"action"`
+	ShelfID       primitive.ObjectID `json:"shelf_id" bson:"shelf_id"`
+	ActionBy      string             `json:"action_by" bson:"action_by"`
+	ActionAt      time.Time          `json:"action_at" bson:"action_at"`
+}
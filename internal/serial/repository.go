@@ -0,0 +1,140 @@
+package serial
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SerialRepository interface {
+	Receive(ctx context.Context, productID primitive.ObjectID, serialNumber string, shelfID primitive.ObjectID, lotNumber string, movement *Movement) (bool, error)
+	Release(ctx context.Context, productID primitive.ObjectID, serialNumber string, shelfID primitive.ObjectID, movement *Movement) (bool, error)
+	GetUnits(ctx context.Context, productID primitive.ObjectID, serialNumbers []string) ([]*Unit, error)
+	GetUnitsBySerialNumber(ctx context.Context, serialNumber string, productID *primitive.ObjectID) ([]*Unit, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+type serialRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSerialRepository(collection *mongo.Collection) SerialRepository {
+	return &serialRepository{
+		collection: collection,
+	}
+}
+
+// Receive puts the unit in stock on the shelf, registering it on first
+// receipt. It reports false when the unit is already in stock somewhere.
+func (r *serialRepository) Receive(ctx context.Context, productID primitive.ObjectID, serialNumber string, shelfID primitive.ObjectID, lotNumber string, movement *Movement) (bool, error) {
+
+	now := time.Now()
+	filter := bson.M{
+		"product_id":    productID,
+		"serial_number": serialNumber,
+		"status":        bson.M{"$ne": StatusInStock},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"status":     StatusInStock,
+			"shelf_id":   shelfID,
+			"lot_number": lotNumber,
+			"updated_at": now,
+		},
+		"$push":        bson.M{"movements": movement},
+		"$setOnInsert": bson.M{"_id": primitive.NewObjectID(), "created_at": now},
+	}
+
+	// A unit already in stock fails the filter, so the upsert collides with
+	// the unique product/serial index instead of registering it twice.
+	_, err := r.collection.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Release takes the unit out of stock. It reports false unless the unit is
+// in stock on the given shelf.
+func (r *serialRepository) Release(ctx context.Context, productID primitive.ObjectID, serialNumber string, shelfID primitive.ObjectID, movement *Movement) (bool, error) {
+
+	filter := bson.M{
+		"product_id":    productID,
+		"serial_number": serialNumber,
+		"status":        StatusInStock,
+		"shelf_id":      shelfID,
+	}
+	update := bson.M{
+		"$set":   bson.M{"status": StatusOut, "updated_at": time.Now()},
+		"$unset": bson.M{"shelf_id": "", "lot_number": ""},
+		"$push":  bson.M{"movements": movement},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+func (r *serialRepository) GetUnits(ctx context.Context, productID primitive.ObjectID, serialNumbers []string) ([]*Unit, error) {
+
+	filter := bson.M{"product_id": productID, "serial_number": bson.M{"$in": serialNumbers}}
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var units []*Unit
+	if err := cursor.All(ctx, &units); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+func (r *serialRepository) GetUnitsBySerialNumber(ctx context.Context, serialNumber string, productID *primitive.ObjectID) ([]*Unit, error) {
+
+	filter := bson.M{"serial_number": serialNumber}
+	if productID != nil {
+		filter["product_id"] = productID
+	}
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var units []*Unit
+	if err := cursor.All(ctx, &units); err != nil {
+		return nil, err
+	}
+
+	return units, nil
+}
+
+func (r *serialRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := r.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "product_id", Value: 1}, {Key: "serial_number", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "serial_number", Value: 1}},
+		},
+	})
+	return err
+}
@@ -0,0 +1,14 @@
+package serial
+
+type MovementResponse struct {
+	*Movement
+	ShelfPath string `json:"shelf_path"`
+}
+
+// UnitResponse is a unit with the path of its current shelf, empty once the
+// unit left stock, and its movements oldest first.
+type UnitResponse struct {
+	*Unit
+	Path      string              `json:"path"`
+	Movements []*MovementResponse `json:"movements"`
+}
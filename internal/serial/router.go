@@ -0,0 +1,18 @@
+package serial
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *SerialHandler) {
+
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/serial").Use(middleware.Secured())
+		{
+			location.GET("/:serial_number", handler.GetBySerialNumber)
+		}
+	}
+}
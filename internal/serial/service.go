@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/internal/storage"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SerialService interface {
+	GetBySerialNumber(ctx context.Context, serialNumber, productID string) ([]*UnitResponse, error)
+}
+
+type serialService struct {
+	repository        SerialRepository
+	storageRepository storage.StorageRepository
+}
+
+func NewSerialService(repository SerialRepository, storageRepository storage.StorageRepository) SerialService {
+	return &serialService{
+		repository:        repository,
+		storageRepository: storageRepository,
+	}
+}
+
+// GetBySerialNumber returns every unit carrying the serial number, one per
+// product unless productID narrows it down.
+func (s *serialService) GetBySerialNumber(ctx context.Context, serialNumber, productID string) ([]*UnitResponse, error) {
+
+	serialNumber = strings.TrimSpace(serialNumber)
+	if serialNumber == "" {
+		return nil, fmt.Errorf("serial_number is required")
+	}
+
+	var objProductID *primitive.ObjectID
+	if productID != "" {
+		id, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id: %w", err)
+		}
+		objProductID = &id
+	}
+
+	units, err := s.repository.GetUnitsBySerialNumber(ctx, serialNumber, objProductID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(units) == 0 {
+		return nil, fmt.Errorf("serial number not found")
+	}
+
+	seen := make(map[primitive.ObjectID]bool)
+	var shelfIDs []primitive.ObjectID
+	for _, unit := range units {
+		for _, movement := range unit.Movements {
+			if !seen[movement.ShelfID] {
+				seen[movement.ShelfID] = true
+				shelfIDs = append(shelfIDs, movement.ShelfID)
+			}
+		}
+	}
+
+	shelves, err := s.storageRepository.GetStoragesByIDs(ctx, shelfIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[primitive.ObjectID]string, len(shelves))
+	for _, shelf := range shelves {
+		paths[shelf.ID] = shelf.Path
+	}
+
+	responses := make([]*UnitResponse, 0, len(units))
+	for _, unit := range units {
+		response := &UnitResponse{Unit: unit, Movements: make([]*MovementResponse, 0, len(unit.Movements))}
+		if unit.ShelfID != nil {
+			response.Path = paths[*unit.ShelfID]
+		}
+		for _, movement := range unit.Movements {
+			response.Movements = append(response.Movements, &MovementResponse{Movement: movement, ShelfPath: paths[movement.ShelfID]})
+		}
+		responses = append(responses, response)
+	}
+
+	return responses, nil
+}
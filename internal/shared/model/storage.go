@@ -19,6 +19,8 @@ type Storage struct {
 	Level       int                  `json:"level" bson:"level"`
 	Path        string               `json:"path" bson:"path"`
 	IsActive    bool                 `json:"is_actice" bson:"is_actice"`
+	ArchivedAt  *time.Time           `json:"archived_at,omitempty" bson:"archived_at,omitempty"`
+	FrozenBy    *primitive.ObjectID  `json:"frozen_by,omitempty" bson:"frozen_by,omitempty"`
 
 	ShelfTypeID *primitive.ObjectID `json:"shelf_type_id,omitempty" bson:"shelf_type_id,omitempty"`
 	ShelfID     *string             `json:"shelf_id" bson:"shelf_id"`
@@ -0,0 +1,213 @@
+// Package mongotest holds the harness shared by the tests that need a Mongo
+// replica set: connecting, seeding a shelf and watching its stock invariants
+// while requests run concurrently.
+package mongotest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"inventory-service/config"
+	"inventory-service/internal/shared/model"
+	"inventory-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// Database connects to the replica set in TEST_MONGO_URI, which transactions
+// need, and returns a throwaway database dropped when the test ends. Without
+// TEST_MONGO_URI the test is skipped, except under CI where it fails so a
+// missing database cannot pass silently.
+func Database(t *testing.T) (*mongo.Client, *mongo.Database) {
+
+	t.Helper()
+
+	uri := os.Getenv("TEST_MONGO_URI")
+	if uri == "" {
+		if os.Getenv("CI") != "" {
+			t.Fatal("TEST_MONGO_URI must be set in CI")
+		}
+		t.Skip("TEST_MONGO_URI is not set, e.g. mongodb://localhost:27017/?replicaSet=rs0")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("connect to %s: %v", uri, err)
+	}
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		t.Fatalf("ping %s: %v", uri, err)
+	}
+
+	database := client.Database(fmt.Sprintf("inventory_test_%s", primitive.NewObjectID().Hex()))
+	t.Cleanup(func() {
+		database.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return client, database
+}
+
+// TypeRules allows root shelves that hold placements.
+func TypeRules() *storage.TypeRules {
+	return storage.NewTypeRules([]config.StorageTypeRule{{Type: "shelf", AllowRoot: true, AllowShelfType: true, AllowPlacement: true}})
+}
+
+// AddShelf stores an empty root shelf of slots times levels.
+func AddShelf(t *testing.T, repository storage.StorageRepository, slots, levels int) *model.Storage {
+
+	t.Helper()
+
+	totalStock := slots * levels
+	shelf := &model.Storage{
+		ID:          primitive.NewObjectID(),
+		Name:        "Shelf",
+		Type:        "shelf",
+		AncestorIDs: []primitive.ObjectID{},
+		Path:        "/Shelf",
+		IsActive:    true,
+		Slots:       &slots,
+		Levels:      &levels,
+		TotalStock:  &totalStock,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	if _, err := repository.AddStorage(context.Background(), shelf); err != nil {
+		t.Fatalf("add shelf: %v", err)
+	}
+
+	return shelf
+}
+
+// ShelfState returns the shelf's TotalStock and the sum of its placements.
+func ShelfState(ctx context.Context, database *mongo.Database, shelfID primitive.ObjectID) (int, int, error) {
+
+	var shelf model.Storage
+	if err := database.Collection("storage").FindOne(ctx, bson.M{"_id": shelfID}).Decode(&shelf); err != nil {
+		return 0, 0, fmt.Errorf("read shelf: %w", err)
+	}
+	if shelf.TotalStock == nil {
+		return 0, 0, fmt.Errorf("shelf has no total_stock")
+	}
+
+	cursor, err := database.Collection("product_placement").Find(ctx, bson.M{"shelf_id": shelfID})
+	if err != nil {
+		return 0, 0, fmt.Errorf("read placements: %w", err)
+	}
+
+	var placements []struct {
+		CurrentQty int `bson:"current_qty"`
+	}
+	if err := cursor.All(ctx, &placements); err != nil {
+		return 0, 0, fmt.Errorf("read placements: %w", err)
+	}
+
+	stocked := 0
+	for _, placement := range placements {
+		if placement.CurrentQty < 0 {
+			return *shelf.TotalStock, placement.CurrentQty, nil
+		}
+		stocked += placement.CurrentQty
+	}
+
+	return *shelf.TotalStock, stocked, nil
+}
+
+// Watch polls the shelf until the returned stop is called, which reports on
+// the test goroutine every time the shelf's TotalStock or a placement was
+// seen below zero.
+func Watch(t *testing.T, database *mongo.Database, shelfID primitive.ObjectID) (stop func()) {
+
+	var mu sync.Mutex
+	var problems []string
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			remaining, stocked, err := ShelfState(context.Background(), database, shelfID)
+			var problem string
+			switch {
+			case err != nil:
+				problem = err.Error()
+			case remaining < 0 || stocked < 0:
+				problem = fmt.Sprintf("went negative: total_stock=%d current_qty=%d", remaining, stocked)
+			}
+			if problem != "" {
+				mu.Lock()
+				problems = append(problems, problem)
+				mu.Unlock()
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+
+	return func() {
+		t.Helper()
+		close(done)
+		wg.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, problem := range problems {
+			t.Error(problem)
+		}
+	}
+}
+
+// CheckInvariant fails the test unless the shelf's TotalStock and placements
+// are not negative and add up to its capacity.
+func CheckInvariant(t *testing.T, database *mongo.Database, shelf *model.Storage) {
+
+	t.Helper()
+
+	remaining, stocked, err := ShelfState(context.Background(), database, shelf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining < 0 || stocked < 0 {
+		t.Errorf("final state negative: total_stock=%d current_qty=%d", remaining, stocked)
+	}
+
+	capacity := (*shelf.Slots) * (*shelf.Levels)
+	if remaining+stocked != capacity {
+		t.Errorf("total_stock %d + current_qty %d = %d, want %d", remaining, stocked, remaining+stocked, capacity)
+	}
+}
+
+// RunConcurrently calls fn for 0..n-1 on n goroutines started together and
+// returns their errors by index.
+func RunConcurrently(n int, fn func(i int) error) []error {
+
+	errs := make([]error, n)
+	start := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			errs[i] = fn(i)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,16 @@
+package ports
+
+import (
+	"context"
+	"inventory-service/internal/shared/model"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ProductPlacement interface {
+	UpdatePlacementLocations(ctx context.Context, storages []*model.Storage) error
+	CountStockedPlacements(ctx context.Context, shelfIDs []primitive.ObjectID) (int64, error)
+	DeletePlacementsByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) error
+	SumQuantityByStorageIDs(ctx context.Context, storageIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+	GetQuantitiesByShelfIDs(ctx context.Context, shelfIDs []primitive.ObjectID) (map[primitive.ObjectID]map[primitive.ObjectID]int, error)
+}
@@ -11,6 +11,7 @@ import (
 type ShelfQuantityRepository interface {
 	CreateShelfQuantity(ctx context.Context, item *ShelfQuantity, userID string) error
 	GetShelfQuantitiesByShelfID(ctx context.Context, shelfID primitive.ObjectID) ([]*ShelfQuantity, error)
+	GetShelfQuantityByCode(ctx context.Context, code string) (*ShelfQuantity, error)
 
 	DeleteQuantity(ctx context.Context, id primitive.ObjectID) error
 }
@@ -52,6 +53,22 @@ func (r *shelfQuantityRepository) GetShelfQuantitiesByShelfID(ctx context.Contex
 	
 }
 
+func (r *shelfQuantityRepository) GetShelfQuantityByCode(ctx context.Context, code string) (*ShelfQuantity, error) {
+
+	var shelfQuantity ShelfQuantity
+
+	err := r.ShelfQuantityCollection.FindOne(ctx, bson.M{"code": code}).Decode(&shelfQuantity)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &shelfQuantity, nil
+
+}
+
 func (r *shelfQuantityRepository) DeleteQuantity(ctx context.Context, id primitive.ObjectID) error {
 
 	filter := bson.M{"shelf_id": id}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"inventory-service/internal/shared/ports"
+	"inventory-service/pkg/constants"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -60,7 +61,7 @@ func (s *shelfQuantityService) CreateShelfQuantity(ctx context.Context, req []Cr
 			return fmt.Errorf("invalid shelf id: %v", err)
 		}
 
-		qrCode := fmt.Sprintf("SENBOX.ORG[INVENTORY]:%s", item.Code)
+		qrCode := constants.InventoryQRPrefix + item.Code
 
 		data := &ShelfQuantity{
 			ID:        primitive.NewObjectID(),
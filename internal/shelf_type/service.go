@@ -76,26 +76,19 @@ func (s *shelfTypeService) GetShelfTypes(ctx context.Context) ([]*ShelfTypeRespo
 		return nil, err
 	}
 
-	var shelfTypes []*ShelfTypeResponse
-
+	imageKeys := make([]string, 0, len(shelves))
 	for _, shelf := range shelves {
+		imageKeys = append(imageKeys, shelf.ImageKey)
+	}
 
-		var imageUrl string
+	imageUrls := s.ImageService.GetImageKeys(ctx, imageKeys)
 
-		urlImage, err := s.ImageService.GetImageKey(ctx, shelf.ImageKey)
-		if err != nil {
-			return nil, err
-		}
-		fmt.Printf("image url: %v\n", urlImage)
-		if urlImage != nil {
-			imageUrl = urlImage.Url
-		} else {
-			imageUrl = ""
-		}
+	var shelfTypes []*ShelfTypeResponse
 
+	for _, shelf := range shelves {
 		shelfTypes = append(shelfTypes, &ShelfTypeResponse{
 			ID:        shelf.ID,
-			ImageUrl:  imageUrl,
+			ImageUrl:  imageUrls[shelf.ImageKey],
 			ImageKey:  shelf.ImageKey,
 			Name:      shelf.Name,
 			Note:      shelf.Note,
@@ -130,22 +123,11 @@ func (s *shelfTypeService) GetShelfTypeByID(ctx context.Context, id string) (*Sh
 		return nil, fmt.Errorf("shelf type not found")
 	}
 
-	var imageUrl string
-
-	urlImage, err := s.ImageService.GetImageKey(ctx, shelf.ImageKey)
-	if err != nil {
-		return nil, err
-	}
-
-	if urlImage != nil {
-		imageUrl = urlImage.Url
-	} else {
-		imageUrl = ""
-	}
+	imageUrls := s.ImageService.GetImageKeys(ctx, []string{shelf.ImageKey})
 
 	shelfType := &ShelfTypeResponse{
 		ID:        shelf.ID,
-		ImageUrl:  imageUrl,
+		ImageUrl:  imageUrls[shelf.ImageKey],
 		ImageKey:  shelf.ImageKey,
 		Name:      shelf.Name,
 		Note:      shelf.Note,
@@ -0,0 +1,100 @@
+package stockhistory
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/helper"
+	"inventory-service/pkg/constants"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StockHistoryHandler struct {
+	StockHistoryService StockHistoryService
+}
+
+func NewStockHistoryHandler(stockHistoryService StockHistoryService) *StockHistoryHandler {
+	return &StockHistoryHandler{
+		StockHistoryService: stockHistoryService,
+	}
+}
+
+func (h *StockHistoryHandler) GetStockAsOf(c *gin.Context) {
+
+	query := &GetStockAsOfQuery{
+		At:        c.Query("at"),
+		ProductID: c.Query("product_id"),
+		StorageID: c.Query("storage_id"),
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	stock, err := h.StockHistoryService.GetStockAsOf(ctx, query)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get stock as of successfully", stock)
+
+}
+
+func (h *StockHistoryHandler) GetSnapshots(c *gin.Context) {
+
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	snapshots, err := h.StockHistoryService.GetSnapshots(ctx, &GetSnapshotsQuery{Page: page, Size: size})
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get stock snapshots successfully", snapshots)
+
+}
+
+func (h *StockHistoryHandler) TakeSnapshot(c *gin.Context) {
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	snapshot, err := h.StockHistoryService.TakeSnapshot(ctx, time.Now().Add(-SnapshotLag))
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Take stock snapshot successfully", snapshot)
+
+}
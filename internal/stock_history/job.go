@@ -0,0 +1,31 @@
+package stockhistory
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunSnapshotJob periodically takes a stock snapshot so as-of queries for
+// old dates replay at most one interval of the ledger. It blocks until ctx
+// is cancelled.
+func RunSnapshotJob(ctx context.Context, service StockHistoryService, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		snapshot, err := service.TakeSnapshot(ctx, time.Now().Add(-SnapshotLag))
+		if err != nil {
+			log.Printf("Failed to take stock snapshot: %v", err)
+		} else {
+			log.Printf("Took stock snapshot %s with %d lines", snapshot.ID.Hex(), snapshot.Lines)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
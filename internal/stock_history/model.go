@@ -0,0 +1,27 @@
+package stockhistory
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Snapshot records the stock of every product on every shelf as it was at
+// TakenAt, so an as-of query only replays the ledger entries after it. Its
+// lines are written before the snapshot itself, which is what makes it
+// visible.
+type Snapshot struct {
+	ID         primitive.ObjectID  `json:"id" bson:"_id"`
+	TakenAt    time.Time           `json:"taken_at" bson:"taken_at"`
+	PreviousID *primitive.ObjectID `json:"previous_id,omitempty" bson:"previous_id,omitempty"`
+	Lines      int                 `json:"lines" bson:"lines"`
+	CreatedAt  time.Time           `json:"created_at" bson:"created_at"`
+}
+
+type SnapshotLine struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id"`
+	SnapshotID primitive.ObjectID `json:"snapshot_id" bson:"snapshot_id"`
+	ProductID  primitive.ObjectID `json:"product_id" bson:"product_id"`
+	ShelfID    primitive.ObjectID `json:"shelf_id" bson:"shelf_id"`
+	Quantity   int                `json:"quantity" bson:"quantity"`
+}
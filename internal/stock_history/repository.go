@@ -0,0 +1,159 @@
+package stockhistory
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const lineBatchSize = 1000
+
+type StockHistoryRepository interface {
+	CreateSnapshot(ctx context.Context, snapshot *Snapshot) error
+	CreateSnapshotLines(ctx context.Context, lines []*SnapshotLine) error
+	GetLatestSnapshot(ctx context.Context, at time.Time) (*Snapshot, error)
+	GetSnapshots(ctx context.Context, skip, limit int64) ([]*Snapshot, int64, error)
+	GetSnapshotLines(ctx context.Context, filter *SnapshotLineFilter) ([]*SnapshotLine, error)
+	EnsureIndexes(ctx context.Context) error
+}
+
+// SnapshotLineFilter narrows the lines of a snapshot; a nil ShelfIDs matches
+// every shelf.
+type SnapshotLineFilter struct {
+	SnapshotID primitive.ObjectID
+	ProductID  *primitive.ObjectID
+	ShelfIDs   []primitive.ObjectID
+}
+
+type stockHistoryRepository struct {
+	collection     *mongo.Collection
+	lineCollection *mongo.Collection
+}
+
+func NewStockHistoryRepository(collection, lineCollection *mongo.Collection) StockHistoryRepository {
+	return &stockHistoryRepository{
+		collection:     collection,
+		lineCollection: lineCollection,
+	}
+}
+
+func (r *stockHistoryRepository) CreateSnapshot(ctx context.Context, snapshot *Snapshot) error {
+
+	_, err := r.collection.InsertOne(ctx, snapshot)
+	return err
+
+}
+
+func (r *stockHistoryRepository) CreateSnapshotLines(ctx context.Context, lines []*SnapshotLine) error {
+
+	for start := 0; start < len(lines); start += lineBatchSize {
+		end := start + lineBatchSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		documents := make([]interface{}, 0, end-start)
+		for _, line := range lines[start:end] {
+			documents = append(documents, line)
+		}
+
+		if _, err := r.lineCollection.InsertMany(ctx, documents); err != nil {
+			return err
+		}
+	}
+
+	return nil
+
+}
+
+// GetLatestSnapshot returns the last snapshot taken at or before at.
+func (r *stockHistoryRepository) GetLatestSnapshot(ctx context.Context, at time.Time) (*Snapshot, error) {
+
+	var snapshot Snapshot
+
+	opts := options.FindOne().SetSort(bson.D{{Key: "taken_at", Value: -1}})
+	err := r.collection.FindOne(ctx, bson.M{"taken_at": bson.M{"$lte": at}}, opts).Decode(&snapshot)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &snapshot, nil
+
+}
+
+func (r *stockHistoryRepository) GetSnapshots(ctx context.Context, skip, limit int64) ([]*Snapshot, int64, error) {
+
+	total, err := r.collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "taken_at", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	snapshots := []*Snapshot{}
+	if err := cursor.All(ctx, &snapshots); err != nil {
+		return nil, 0, err
+	}
+
+	return snapshots, total, nil
+
+}
+
+func (r *stockHistoryRepository) GetSnapshotLines(ctx context.Context, filter *SnapshotLineFilter) ([]*SnapshotLine, error) {
+
+	query := bson.M{"snapshot_id": filter.SnapshotID}
+	if filter.ProductID != nil {
+		query["product_id"] = filter.ProductID
+	}
+	if filter.ShelfIDs != nil {
+		query["shelf_id"] = bson.M{"$in": filter.ShelfIDs}
+	}
+
+	cursor, err := r.lineCollection.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var lines []*SnapshotLine
+	if err := cursor.All(ctx, &lines); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+
+}
+
+func (r *stockHistoryRepository) EnsureIndexes(ctx context.Context) error {
+
+	_, err := r.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "taken_at", Value: -1}},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.lineCollection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "snapshot_id", Value: 1}, {Key: "product_id", Value: 1}, {Key: "shelf_id", Value: 1}}},
+		{Keys: bson.D{{Key: "snapshot_id", Value: 1}, {Key: "shelf_id", Value: 1}}},
+	})
+	return err
+
+}
@@ -0,0 +1,15 @@
+package stockhistory
+
+// GetStockAsOfQuery asks for the stock at At, an RFC 3339 timestamp or a
+// plain date meaning the end of that day. StorageID covers a shelf or every
+// shelf below a storage.
+type GetStockAsOfQuery struct {
+	At        string
+	ProductID string
+	StorageID string
+}
+
+type GetSnapshotsQuery struct {
+	Page int
+	Size int
+}
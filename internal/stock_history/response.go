@@ -0,0 +1,31 @@
+package stockhistory
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type StockAsOfItem struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	ShelfID   primitive.ObjectID `json:"shelf_id"`
+	Path      string             `json:"path"`
+	Quantity  int                `json:"quantity"`
+}
+
+// StockAsOfResponse names the snapshot the replay started from, if any.
+type StockAsOfResponse struct {
+	At         time.Time           `json:"at"`
+	SnapshotID *primitive.ObjectID `json:"snapshot_id,omitempty"`
+	SnapshotAt *time.Time          `json:"snapshot_at,omitempty"`
+	Total      int                 `json:"total"`
+	Items      []*StockAsOfItem    `json:"items"`
+}
+
+type SnapshotListResponse struct {
+	Items      []*Snapshot `json:"items"`
+	Total      int64       `json:"total"`
+	Page       int         `json:"page"`
+	Size       int         `json:"size"`
+	TotalPages int         `json:"total_pages"`
+}
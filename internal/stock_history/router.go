@@ -0,0 +1,20 @@
+package stockhistory
+
+import (
+	"inventory-service/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func RegisterRoutes(r *gin.Engine, handler *StockHistoryHandler) {
+
+	api := r.Group("api/v1")
+	{
+		location := api.Group("/stock_history").Use(middleware.Secured())
+		{
+			location.GET("", handler.GetStockAsOf)
+			location.GET("/snapshots", handler.GetSnapshots)
+			location.POST("/snapshots", handler.TakeSnapshot)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package stockhistory
+
+import (
+	"context"
+	"fmt"
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/storage"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+
+	// SnapshotLag keeps snapshots clear of transactions still committing
+	// entries stamped just before the snapshot time.
+	SnapshotLag = 5 * time.Minute
+)
+
+type StockHistoryService interface {
+	GetStockAsOf(ctx context.Context, query *GetStockAsOfQuery) (*StockAsOfResponse, error)
+	TakeSnapshot(ctx context.Context, at time.Time) (*Snapshot, error)
+	GetSnapshots(ctx context.Context, query *GetSnapshotsQuery) (*SnapshotListResponse, error)
+}
+
+type stockHistoryService struct {
+	repository            StockHistoryRepository
+	transactionRepository producttransaction.ProductTransactionRepository
+	storageRepository     storage.StorageRepository
+}
+
+func NewStockHistoryService(
+	repository StockHistoryRepository,
+	transactionRepository producttransaction.ProductTransactionRepository,
+	storageRepository storage.StorageRepository,
+) StockHistoryService {
+	return &stockHistoryService{
+		repository:            repository,
+		transactionRepository: transactionRepository,
+		storageRepository:     storageRepository,
+	}
+}
+
+type stockKey struct {
+	productID primitive.ObjectID
+	shelfID   primitive.ObjectID
+}
+
+// replay returns the stock at `at` of the lines matched by the filters,
+// starting from the last snapshot before it and adding the ledger entries
+// written since.
+func (s *stockHistoryService) replay(ctx context.Context, at time.Time, productID *primitive.ObjectID, shelfIDs []primitive.ObjectID) (map[stockKey]int, *Snapshot, error) {
+
+	snapshot, err := s.repository.GetLatestSnapshot(ctx, at)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	quantities := make(map[stockKey]int)
+	filter := &producttransaction.ProductTransactionFilter{
+		ProductID: productID,
+		ShelfIDs:  shelfIDs,
+		To:        &at,
+	}
+
+	if snapshot != nil {
+		lines, err := s.repository.GetSnapshotLines(ctx, &SnapshotLineFilter{
+			SnapshotID: snapshot.ID,
+			ProductID:  productID,
+			ShelfIDs:   shelfIDs,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, line := range lines {
+			quantities[stockKey{productID: line.ProductID, shelfID: line.ShelfID}] += line.Quantity
+		}
+		filter.From = &snapshot.TakenAt
+	}
+
+	deltas, err := s.transactionRepository.SumQuantities(ctx, filter)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, delta := range deltas {
+		quantities[stockKey{productID: delta.ProductID, shelfID: delta.ShelfID}] += delta.Quantity
+	}
+
+	for key, quantity := range quantities {
+		if quantity == 0 {
+			delete(quantities, key)
+		}
+	}
+
+	return quantities, snapshot, nil
+}
+
+// GetStockAsOf reconstructs the stock of a product, a storage subtree or both
+// at a past time. The subtree is resolved against the current hierarchy,
+// archived storages included.
+func (s *stockHistoryService) GetStockAsOf(ctx context.Context, query *GetStockAsOfQuery) (*StockAsOfResponse, error) {
+
+	at, err := parseAt(query.At)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.ProductID == "" && query.StorageID == "" {
+		return nil, fmt.Errorf("product_id or storage_id is required")
+	}
+
+	var productID *primitive.ObjectID
+	if query.ProductID != "" {
+		objProductID, err := primitive.ObjectIDFromHex(query.ProductID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid product id: %v", err)
+		}
+		productID = &objProductID
+	}
+
+	var shelfIDs []primitive.ObjectID
+	if query.StorageID != "" {
+		storageID, err := primitive.ObjectIDFromHex(query.StorageID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid storage id: %v", err)
+		}
+
+		// Storages archived since `at` held stock then, so the subtree
+		// includes archived storages.
+		root, err := s.storageRepository.GetStorageByIDWithArchived(ctx, storageID)
+		if err != nil {
+			return nil, err
+		}
+		if root == nil {
+			return nil, fmt.Errorf("storage not found")
+		}
+
+		shelfIDs = []primitive.ObjectID{root.ID}
+
+		filter := &storage.StorageFilter{
+			AncestorID:      &storageID,
+			IncludeArchived: true,
+			SortField:       "path",
+			SortOrder:       1,
+		}
+
+		err = s.storageRepository.ForEachStorage(ctx, filter, func(descendant *storage.Storage) error {
+			shelfIDs = append(shelfIDs, descendant.ID)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	quantities, snapshot, err := s.replay(ctx, at, productID, shelfIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &StockAsOfResponse{At: at, Items: make([]*StockAsOfItem, 0, len(quantities))}
+	if snapshot != nil {
+		response.SnapshotID = &snapshot.ID
+		response.SnapshotAt = &snapshot.TakenAt
+	}
+
+	var ids []primitive.ObjectID
+	seen := make(map[primitive.ObjectID]bool)
+	for key, quantity := range quantities {
+		response.Total += quantity
+		response.Items = append(response.Items, &StockAsOfItem{
+			ProductID: key.productID,
+			ShelfID:   key.shelfID,
+			Quantity:  quantity,
+		})
+		if !seen[key.shelfID] {
+			seen[key.shelfID] = true
+			ids = append(ids, key.shelfID)
+		}
+	}
+
+	shelves, err := s.storageRepository.GetStoragesByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[primitive.ObjectID]string, len(shelves))
+	for _, shelf := range shelves {
+		paths[shelf.ID] = shelf.Path
+	}
+
+	for _, item := range response.Items {
+		item.Path = paths[item.ShelfID]
+	}
+
+	sort.Slice(response.Items, func(i, j int) bool {
+		if response.Items[i].Path != response.Items[j].Path {
+			return response.Items[i].Path < response.Items[j].Path
+		}
+		return response.Items[i].ProductID.Hex() < response.Items[j].ProductID.Hex()
+	})
+
+	return response, nil
+}
+
+// TakeSnapshot stores the stock of every product and shelf at `at`, built
+// from the previous snapshot and the ledger entries since, so each snapshot
+// only replays one interval.
+func (s *stockHistoryService) TakeSnapshot(ctx context.Context, at time.Time) (*Snapshot, error) {
+
+	quantities, previous, err := s.replay(ctx, at, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil && previous.TakenAt.Equal(at) {
+		return previous, nil
+	}
+
+	snapshot := &Snapshot{
+		ID:        primitive.NewObjectID(),
+		TakenAt:   at,
+		Lines:     len(quantities),
+		CreatedAt: time.Now(),
+	}
+	if previous != nil {
+		snapshot.PreviousID = &previous.ID
+	}
+
+	lines := make([]*SnapshotLine, 0, len(quantities))
+	for key, quantity := range quantities {
+		lines = append(lines, &SnapshotLine{
+			ID:         primitive.NewObjectID(),
+			SnapshotID: snapshot.ID,
+			ProductID:  key.productID,
+			ShelfID:    key.shelfID,
+			Quantity:   quantity,
+		})
+	}
+
+	if err := s.repository.CreateSnapshotLines(ctx, lines); err != nil {
+		return nil, err
+	}
+
+	if err := s.repository.CreateSnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+func (s *stockHistoryService) GetSnapshots(ctx context.Context, query *GetSnapshotsQuery) (*SnapshotListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	snapshots, total, err := s.repository.GetSnapshots(ctx, int64((page-1)*size), int64(size))
+	if err != nil {
+		return nil, err
+	}
+
+	return &SnapshotListResponse{
+		Items:      snapshots,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
+}
+
+// parseAt accepts an RFC 3339 timestamp or a plain date, which means the end
+// of that day. The bound is exclusive: entries written at `at` are not
+// included.
+func parseAt(value string) (time.Time, error) {
+
+	if value == "" {
+		return time.Time{}, fmt.Errorf("at is required")
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid at: %v", err)
+	}
+
+	return t.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,172 @@
+package stockhistory_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	producttransaction "inventory-service/internal/product_transaction"
+	"inventory-service/internal/shared/model"
+	stockhistory "inventory-service/internal/stock_history"
+	"inventory-service/internal/storage"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeSnapshots has no snapshots, so every query replays the whole ledger.
+type fakeSnapshots struct {
+	stockhistory.StockHistoryRepository
+}
+
+func (f *fakeSnapshots) GetLatestSnapshot(ctx context.Context, at time.Time) (*stockhistory.Snapshot, error) {
+	return nil, nil
+}
+
+type fakeLedger struct {
+	producttransaction.ProductTransactionRepository
+	deltas []*producttransaction.StockDelta
+}
+
+func (f *fakeLedger) SumQuantities(ctx context.Context, filter *producttransaction.ProductTransactionFilter) ([]*producttransaction.StockDelta, error) {
+
+	var deltas []*producttransaction.StockDelta
+	for _, delta := range f.deltas {
+		if filter.ShelfIDs != nil && !containsID(filter.ShelfIDs, delta.ShelfID) {
+			continue
+		}
+		if filter.ProductID != nil && *filter.ProductID != delta.ProductID {
+			continue
+		}
+		deltas = append(deltas, delta)
+	}
+
+	return deltas, nil
+}
+
+// fakeStorages answers like the Mongo repository: the plain lookups only see
+// active storages.
+type fakeStorages struct {
+	storage.StorageRepository
+	storages []*model.Storage
+}
+
+func (f *fakeStorages) GetStorageByID(ctx context.Context, id *primitive.ObjectID) (*model.Storage, error) {
+
+	for _, s := range f.storages {
+		if s.ID == *id && s.IsActive {
+			return s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeStorages) GetStorageByIDWithArchived(ctx context.Context, id primitive.ObjectID) (*model.Storage, error) {
+
+	for _, s := range f.storages {
+		if s.ID == id {
+			return s, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (f *fakeStorages) GetTreeNodes(ctx context.Context, ancestorID *primitive.ObjectID, maxLevel int) ([]*storage.Storage, error) {
+
+	var nodes []*storage.Storage
+	for _, s := range f.storages {
+		if s.IsActive && containsID(s.AncestorIDs, *ancestorID) {
+			nodes = append(nodes, (*storage.Storage)(s))
+		}
+	}
+
+	return nodes, nil
+}
+
+func (f *fakeStorages) ForEachStorage(ctx context.Context, filter *storage.StorageFilter, fn func(*storage.Storage) error) error {
+
+	for _, s := range f.storages {
+		if !filter.IncludeArchived && !s.IsActive {
+			continue
+		}
+		if filter.AncestorID != nil && !containsID(s.AncestorIDs, *filter.AncestorID) {
+			continue
+		}
+		if err := fn((*storage.Storage)(s)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (f *fakeStorages) GetStoragesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*model.Storage, error) {
+
+	var storages []*model.Storage
+	for _, s := range f.storages {
+		if containsID(ids, s.ID) {
+			storages = append(storages, s)
+		}
+	}
+
+	return storages, nil
+}
+
+func containsID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestGetStockAsOfIncludesArchivedShelves(t *testing.T) {
+
+	archivedAt := time.Now().Add(-time.Hour)
+	room := &model.Storage{ID: primitive.NewObjectID(), Type: "room", Path: "/Room", IsActive: true}
+	active := &model.Storage{ID: primitive.NewObjectID(), Type: "shelf", Path: "/Room/A", IsActive: true, ParentID: &room.ID, AncestorIDs: []primitive.ObjectID{room.ID}}
+	archived := &model.Storage{ID: primitive.NewObjectID(), Type: "shelf", Path: "/Room/B", IsActive: false, ArchivedAt: &archivedAt, ParentID: &room.ID, AncestorIDs: []primitive.ObjectID{room.ID}}
+
+	productID := primitive.NewObjectID()
+	ledger := &fakeLedger{deltas: []*producttransaction.StockDelta{
+		{ProductID: productID, ShelfID: active.ID, Quantity: 5},
+		{ProductID: productID, ShelfID: archived.ID, Quantity: 3},
+	}}
+
+	service := stockhistory.NewStockHistoryService(&fakeSnapshots{}, ledger, &fakeStorages{storages: []*model.Storage{room, active, archived}})
+	at := archivedAt.Add(-time.Hour).Format(time.RFC3339)
+
+	tests := []struct {
+		name      string
+		storageID primitive.ObjectID
+		total     int
+		paths     []string
+	}{
+		{name: "archived shelf under the queried storage", storageID: room.ID, total: 8, paths: []string{"/Room/A", "/Room/B"}},
+		{name: "archived shelf queried directly", storageID: archived.ID, total: 3, paths: []string{"/Room/B"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := service.GetStockAsOf(context.Background(), &stockhistory.GetStockAsOfQuery{At: at, StorageID: tt.storageID.Hex()})
+			if err != nil {
+				t.Fatalf("GetStockAsOf: %v", err)
+			}
+			if response.Total != tt.total {
+				t.Errorf("total = %d, want %d", response.Total, tt.total)
+			}
+			if len(response.Items) != len(tt.paths) {
+				t.Fatalf("got %d items, want %d", len(response.Items), len(tt.paths))
+			}
+			for i, item := range response.Items {
+				if item.Path != tt.paths[i] {
+					t.Errorf("item %d path = %q, want %q", i, item.Path, tt.paths[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ExportCSV  = "csv"
+	ExportXLSX = "xlsx"
+	ExportJSON = "json"
+
+	exportBatchSize = 500
+	exportSheet     = "Sheet1"
+)
+
+var exportContentTypes = map[string]string{
+	ExportCSV:  "text/csv; charset=utf-8",
+	ExportXLSX: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	ExportJSON: "application/json; charset=utf-8",
+}
+
+type ExportPlacement struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	Quantity  int                `json:"quantity"`
+}
+
+type ExportRecord struct {
+	ID         primitive.ObjectID `json:"id"`
+	Path       string             `json:"path"`
+	Name       string             `json:"name"`
+	Type       string             `json:"type"`
+	ShelfType  string             `json:"shelf_type"`
+	Slots      *int               `json:"slots"`
+	Levels     *int               `json:"levels"`
+	TotalStock *int               `json:"total_stock"`
+	IsActive   bool               `json:"is_active"`
+	Placements []*ExportPlacement `json:"placements,omitempty"`
+}
+
+func (s *storageService) ExportStorages(ctx context.Context, query *ExportStoragesQuery, w io.Writer) error {
+
+	if _, ok := exportContentTypes[query.Format]; !ok {
+		return fmt.Errorf("unknown export format %q, use csv, xlsx or json", query.Format)
+	}
+
+	filter := &StorageFilter{
+		Type:            query.Type,
+		IncludeArchived: query.IncludeArchived,
+		SortField:       "path",
+		SortOrder:       1,
+	}
+
+	var root *Storage
+	if query.AncestorID != "" {
+		ancestorID, err := primitive.ObjectIDFromHex(query.AncestorID)
+		if err != nil {
+			return fmt.Errorf("invalid ancestor id: %w", err)
+		}
+
+		storage, err := s.repository.GetStorageByIDWithArchived(ctx, ancestorID)
+		if err != nil {
+			return err
+		}
+		if storage == nil || (!storage.IsActive && !query.IncludeArchived) {
+			return fmt.Errorf("storage not found")
+		}
+
+		filter.AncestorID = &ancestorID
+		if query.Type == "" || storage.Type == query.Type {
+			root = (*Storage)(storage)
+		}
+	}
+
+	shelfTypes, err := s.shelfTypeRepository.GetShelfTypes(ctx)
+	if err != nil {
+		return err
+	}
+
+	shelfTypeNames := make(map[primitive.ObjectID]string, len(shelfTypes))
+	for _, shelfType := range shelfTypes {
+		shelfTypeNames[shelfType.ID] = shelfType.Name
+	}
+
+	writer := newExportWriter(query.Format, w, query.IncludePlacements)
+
+	batch := make([]*Storage, 0, exportBatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+
+		var quantities map[primitive.ObjectID]map[primitive.ObjectID]int
+		if query.IncludePlacements {
+			ids := make([]primitive.ObjectID, 0, len(batch))
+			for _, storage := range batch {
+				ids = append(ids, storage.ID)
+			}
+			quantities, err = s.placementRepo.GetQuantitiesByShelfIDs(ctx, ids)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, storage := range batch {
+			record := &ExportRecord{
+				ID:         storage.ID,
+				Path:       storage.Path,
+				Name:       storage.Name,
+				Type:       storage.Type,
+				Slots:      storage.Slots,
+				Levels:     storage.Levels,
+				TotalStock: storage.TotalStock,
+				IsActive:   storage.IsActive,
+			}
+			if storage.ShelfTypeID != nil {
+				record.ShelfType = shelfTypeNames[*storage.ShelfTypeID]
+			}
+			if query.IncludePlacements {
+				record.Placements = exportPlacements(quantities[storage.ID])
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+
+		batch = batch[:0]
+		return nil
+	}
+
+	collect := func(storage *Storage) error {
+		batch = append(batch, storage)
+		if len(batch) < exportBatchSize {
+			return nil
+		}
+		return flush()
+	}
+
+	if root != nil {
+		if err := collect(root); err != nil {
+			return err
+		}
+	}
+
+	if err := s.repository.ForEachStorage(ctx, filter, collect); err != nil {
+		return err
+	}
+
+	if err := flush(); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+func exportPlacements(quantities map[primitive.ObjectID]int) []*ExportPlacement {
+
+	placements := make([]*ExportPlacement, 0, len(quantities))
+	for productID, quantity := range quantities {
+		placements = append(placements, &ExportPlacement{ProductID: productID, Quantity: quantity})
+	}
+
+	sort.Slice(placements, func(i, j int) bool {
+		return placements[i].ProductID.Hex() < placements[j].ProductID.Hex()
+	})
+
+	return placements
+}
+
+type exportWriter interface {
+	Write(record *ExportRecord) error
+	Close() error
+}
+
+func newExportWriter(format string, w io.Writer, includePlacements bool) exportWriter {
+
+	switch format {
+	case ExportJSON:
+		return &jsonExportWriter{w: w}
+	case ExportXLSX:
+		return &xlsxExportWriter{w: w, includePlacements: includePlacements}
+	default:
+		return &csvExportWriter{w: csv.NewWriter(w), includePlacements: includePlacements}
+	}
+}
+
+func exportHeader(includePlacements bool) []string {
+
+	header := []string{"id", "path", "name", "type", "shelf_type", "slots", "levels", "total_stock", "is_active"}
+	if includePlacements {
+		header = append(header, "product_id", "quantity")
+	}
+
+	return header
+}
+
+// exportRows flattens a record into one row, or one row per product when
+// placements are included. Storages without stock keep a single row with
+// empty product columns.
+func exportRows(record *ExportRecord, includePlacements bool) [][]string {
+
+	optional := func(value *int) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.Itoa(*value)
+	}
+
+	base := []string{
+		record.ID.Hex(),
+		record.Path,
+		record.Name,
+		record.Type,
+		record.ShelfType,
+		optional(record.Slots),
+		optional(record.Levels),
+		optional(record.TotalStock),
+		strconv.FormatBool(record.IsActive),
+	}
+
+	if !includePlacements {
+		return [][]string{base}
+	}
+
+	if len(record.Placements) == 0 {
+		return [][]string{append(base, "", "")}
+	}
+
+	rows := make([][]string, 0, len(record.Placements))
+	for _, placement := range record.Placements {
+		row := append(append([]string{}, base...), placement.ProductID.Hex(), strconv.Itoa(placement.Quantity))
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+type csvExportWriter struct {
+	w                 *csv.Writer
+	includePlacements bool
+	started           bool
+}
+
+func (e *csvExportWriter) Write(record *ExportRecord) error {
+
+	if !e.started {
+		e.started = true
+		if err := e.w.Write(exportHeader(e.includePlacements)); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range exportRows(record, e.includePlacements) {
+		if err := e.w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *csvExportWriter) Close() error {
+
+	if !e.started {
+		e.started = true
+		if err := e.w.Write(exportHeader(e.includePlacements)); err != nil {
+			return err
+		}
+	}
+
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonExportWriter writes a JSON array one element at a time instead of
+// marshalling the whole export at once.
+type jsonExportWriter struct {
+	w     io.Writer
+	count int
+}
+
+func (e *jsonExportWriter) Write(record *ExportRecord) error {
+
+	separator := ","
+	if e.count == 0 {
+		separator = "["
+	}
+	e.count++
+
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonExportWriter) Close() error {
+
+	closing := "]"
+	if e.count == 0 {
+		closing = "[]"
+	}
+
+	_, err := io.WriteString(e.w, closing)
+	return err
+}
+
+// xlsxExportWriter uses excelize's stream writer, which spills rows to a
+// temporary file, so memory stays flat; the workbook is sent on Close.
+type xlsxExportWriter struct {
+	w                 io.Writer
+	includePlacements bool
+	file              *excelize.File
+	stream            *excelize.StreamWriter
+	row               int
+}
+
+func (e *xlsxExportWriter) start() error {
+
+	e.file = excelize.NewFile()
+
+	stream, err := e.file.NewStreamWriter(exportSheet)
+	if err != nil {
+		return err
+	}
+	e.stream = stream
+
+	return e.writeRow(exportHeader(e.includePlacements))
+}
+
+func (e *xlsxExportWriter) writeRow(values []string) error {
+
+	e.row++
+
+	cells := make([]interface{}, len(values))
+	for i, value := range values {
+		cells[i] = value
+	}
+
+	cell, err := excelize.CoordinatesToCellName(1, e.row)
+	if err != nil {
+		return err
+	}
+
+	return e.stream.SetRow(cell, cells)
+}
+
+func (e *xlsxExportWriter) Write(record *ExportRecord) error {
+
+	if e.file == nil {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	for _, row := range exportRows(record, e.includePlacements) {
+		if err := e.writeRow(row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *xlsxExportWriter) Close() error {
+
+	if e.file == nil {
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+	defer e.file.Close()
+
+	if err := e.stream.Flush(); err != nil {
+		return err
+	}
+
+	return e.file.Write(e.w)
+}
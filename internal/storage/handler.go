@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"inventory-service/helper"
 	"inventory-service/pkg/constants"
+	"log"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -53,7 +56,29 @@ func (h *StorageHandler) CreateStorage(c *gin.Context) {
 
 func (h *StorageHandler) GetStoragies(c *gin.Context) {
 
-	typeString := c.Query("type")
+	page, err := strconv.Atoi(c.DefaultQuery(constants.Page, "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid page: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	size, err := strconv.Atoi(c.DefaultQuery(constants.Size, "20"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid size: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	query := &GetStoragiesQuery{
+		Page:            page,
+		Size:            size,
+		Search:          c.Query(constants.Search),
+		Type:            c.Query("type"),
+		ParentID:        c.Query("parent_id"),
+		AncestorID:      c.Query("ancestor_id"),
+		CreatedBy:       c.Query("created_by"),
+		Sort:            c.Query("sort"),
+		IncludeArchived: c.Query("include_archived") == "true",
+	}
 
 	token, exists := c.Get(constants.Token)
 	if !exists {
@@ -63,7 +88,7 @@ func (h *StorageHandler) GetStoragies(c *gin.Context) {
 	
 	ctx := context.WithValue(c, constants.TokenKey, token)
 
-	storagies, err := h.StorageService.GetStoragies(ctx, typeString)
+	storagies, err := h.StorageService.GetStoragies(ctx, query)
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
 		return
@@ -96,6 +121,12 @@ func (h *StorageHandler) GetStorageByID(c *gin.Context) {
 
 func (h *StorageHandler) GetStorageTree(c *gin.Context) {
 
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "0"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid depth: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
 	token, exists := c.Get(constants.Token)
 	if !exists {
 		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
@@ -104,7 +135,7 @@ func (h *StorageHandler) GetStorageTree(c *gin.Context) {
 	
 	ctx := context.WithValue(c, constants.TokenKey, token)
 
-	storageTree, err := h.StorageService.GetStorageTree(ctx)
+	storageTree, err := h.StorageService.GetStorageTree(ctx, depth)
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
 		return
@@ -114,6 +145,34 @@ func (h *StorageHandler) GetStorageTree(c *gin.Context) {
 	
 }
 
+func (h *StorageHandler) GetStorageChildren(c *gin.Context) {
+
+	id := c.Param("id")
+
+	depth, err := strconv.Atoi(c.DefaultQuery("depth", "1"))
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("invalid depth: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	children, err := h.StorageService.GetStorageChildren(ctx, id, depth)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get storage children successfully", children)
+
+}
+
 func (h *StorageHandler) UpdateStorage(c *gin.Context) {
 
 	id := c.Param("id")
@@ -143,24 +202,215 @@ func (h *StorageHandler) UpdateStorage(c *gin.Context) {
 
 }
 
-func (h *StorageHandler) DeleteStorage(c *gin.Context) {
+func (h *StorageHandler) MoveStorage(c *gin.Context) {
 
 	id := c.Param("id")
 
+	var req MoveStorageRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
 	token, exists := c.Get(constants.Token)
 	if !exists {
 		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
 		return
 	}
-	
+
 	ctx := context.WithValue(c, constants.TokenKey, token)
 
-	err := h.StorageService.DeleteStorage(ctx, id)
+	err := h.StorageService.MoveStorage(ctx, id, &req)
 	if err != nil {
 		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
 		return
 	}
 
-	helper.SendSuccess(c, 200, "Delete storage successfully", nil)
-	
-}
\ No newline at end of file
+	helper.SendSuccess(c, 200, "Move storage successfully", nil)
+
+}
+
+func (h *StorageHandler) ArchiveStorage(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.StorageService.ArchiveStorage(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Archive storage successfully", nil)
+
+}
+
+func (h *StorageHandler) RestoreStorage(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	err := h.StorageService.RestoreStorage(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Restore storage successfully", nil)
+
+}
+
+func (h *StorageHandler) ImportStorages(c *gin.Context) {
+
+	userID, exists := c.Get(constants.UserID)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("user_id not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		helper.SendError(c, 400, fmt.Errorf("file is required: %w", err), helper.ErrInvalidRequest)
+		return
+	}
+
+	if fileHeader.Size > MaxImportFileSize {
+		helper.SendError(c, 400, fmt.Errorf("file is too large, at most %d MB", MaxImportFileSize>>20), helper.ErrInvalidRequest)
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+	defer file.Close()
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	result, err := h.StorageService.ImportStorages(ctx, file, fileHeader.Filename, c.Query("dry_run") == "true", userID.(string))
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	if len(result.Errors) > 0 {
+		c.JSON(400, helper.APIResponse{
+			StatusCode: 400,
+			Message:    fmt.Sprintf("Import has %d invalid rows", len(result.Errors)),
+			Data:       result,
+			ErrorCode:  helper.ErrInvalidRequest,
+		})
+		return
+	}
+
+	if result.DryRun {
+		helper.SendSuccess(c, 200, "Validate import successfully", result)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Import storage successfully", result)
+
+}
+
+func (h *StorageHandler) ExportStorages(c *gin.Context) {
+
+	query := &ExportStoragesQuery{
+		Format:            c.DefaultQuery("format", ExportCSV),
+		AncestorID:        c.Query("ancestor_id"),
+		Type:              c.Query("type"),
+		IncludeArchived:   c.Query("include_archived") == "true",
+		IncludePlacements: c.Query("include_placements") == "true",
+	}
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	writer := &exportResponseWriter{
+		c:           c,
+		contentType: exportContentTypes[query.Format],
+		fileName:    fmt.Sprintf("storage-export-%s.%s", time.Now().Format("20060102-150405"), query.Format),
+	}
+
+	if err := h.StorageService.ExportStorages(ctx, query, writer); err != nil {
+		if writer.started {
+			// Headers and part of the body are already sent, all we can do is
+			// cut the response short.
+			log.Printf("export storages: %v", err)
+			c.Abort()
+			return
+		}
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+}
+
+// exportResponseWriter sends the download headers on the first write so a
+// failure before any data is produced can still be reported as JSON.
+type exportResponseWriter struct {
+	c           *gin.Context
+	contentType string
+	fileName    string
+	started     bool
+}
+
+func (w *exportResponseWriter) Write(p []byte) (int, error) {
+
+	if !w.started {
+		w.started = true
+		w.c.Header("Content-Type", w.contentType)
+		w.c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", w.fileName))
+		w.c.Status(200)
+	}
+
+	return w.c.Writer.Write(p)
+}
+
+func (h *StorageHandler) GetStorageUtilization(c *gin.Context) {
+
+	id := c.Param("id")
+
+	token, exists := c.Get(constants.Token)
+	if !exists {
+		helper.SendError(c, 400, fmt.Errorf("token not found"), helper.ErrInvalidRequest)
+		return
+	}
+
+	ctx := context.WithValue(c, constants.TokenKey, token)
+
+	utilization, err := h.StorageService.GetStorageUtilization(ctx, id)
+	if err != nil {
+		helper.SendError(c, 400, err, helper.ErrInvalidRequest)
+		return
+	}
+
+	helper.SendSuccess(c, 200, "Get storage utilization successfully", utilization)
+
+}
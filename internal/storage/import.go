@@ -0,0 +1,384 @@
+package storage
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"inventory-service/internal/shared/model"
+	"inventory-service/pkg/constants"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	MaxImportFileSize = 10 << 20
+	maxImportRows     = 5000
+)
+
+// importColumns maps accepted header names to the canonical column.
+var importColumns = map[string]string{
+	"parent_path":     "parent_path",
+	"parent":          "parent_path",
+	"name":            "name",
+	"type":            "type",
+	"shelf_type":      "shelf_type",
+	"shelf_type_name": "shelf_type",
+	"description":     "description",
+}
+
+type importRow struct {
+	line        int
+	parentPath  string
+	name        string
+	typ         string
+	shelfType   string
+	description string
+	path        string
+	depth       int
+}
+
+func (s *storageService) ImportStorages(ctx context.Context, file io.Reader, fileName string, dryRun bool, userID string) (*ImportResult, error) {
+
+	records, err := readImportRecords(file, fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := parseImportRows(records)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{
+		DryRun: dryRun,
+		Total:  len(rows),
+		Items:  []*ImportedStorage{},
+		Errors: []*ImportRowError{},
+	}
+
+	storagies, err := s.validateImportRows(ctx, rows, userID, result)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		return result, nil
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, s.repository.AddStorages(sc, storagies)
+	}
+
+	if _, err := session.WithTransaction(ctx, callback); err != nil {
+		return nil, err
+	}
+
+	result.Created = len(storagies)
+
+	return result, nil
+}
+
+func readImportRecords(file io.Reader, fileName string) ([][]string, error) {
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		reader := csv.NewReader(file)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, fmt.Errorf("invalid csv file: %w", err)
+		}
+		return records, nil
+	case ".xlsx":
+		workbook, err := excelize.OpenReader(file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xlsx file: %w", err)
+		}
+		defer workbook.Close()
+
+		sheets := workbook.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, fmt.Errorf("xlsx file has no sheets")
+		}
+		return workbook.GetRows(sheets[0])
+	default:
+		return nil, fmt.Errorf("unsupported file type %q, use .csv or .xlsx", filepath.Ext(fileName))
+	}
+}
+
+func parseImportRows(records [][]string) ([]*importRow, error) {
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	columns := make(map[string]int)
+	for i, header := range records[0] {
+		header = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(header, "\ufeff")))
+		if column, ok := importColumns[header]; ok {
+			columns[column] = i
+		}
+	}
+
+	for _, required := range []string{"name", "type"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing %q column", required)
+		}
+	}
+
+	cell := func(record []string, column string) string {
+		i, ok := columns[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var rows []*importRow
+	for i, record := range records[1:] {
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		rows = append(rows, &importRow{
+			line:        i + 2,
+			parentPath:  normalizePath(cell(record, "parent_path")),
+			name:        cell(record, "name"),
+			typ:         cell(record, "type"),
+			shelfType:   cell(record, "shelf_type"),
+			description: cell(record, "description"),
+		})
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("file has no rows")
+	}
+
+	if len(rows) > maxImportRows {
+		return nil, fmt.Errorf("too many rows, at most %d per import", maxImportRows)
+	}
+
+	return rows, nil
+}
+
+// normalizePath turns "Warehouse A/Floor 1/" into "/Warehouse A/Floor 1" to
+// match the stored Path format. An empty path refers to the root.
+func normalizePath(path string) string {
+
+	path = strings.Trim(strings.TrimSpace(path), "/")
+	if path == "" {
+		return ""
+	}
+
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = strings.TrimSpace(segment)
+	}
+
+	return "/" + strings.Join(segments, "/")
+}
+
+// validateImportRows checks every row against the file itself, the existing
+// hierarchy and the type rules, and builds the storages to insert. Rows are
+// returned parent first so the hierarchy fields can be derived in one pass.
+func (s *storageService) validateImportRows(ctx context.Context, rows []*importRow, userID string, result *ImportResult) ([]*model.Storage, error) {
+
+	addError := func(row *importRow, field, message string) {
+		result.Errors = append(result.Errors, &ImportRowError{Row: row.line, Field: field, Message: message})
+	}
+
+	fileRows := make(map[string]*importRow, len(rows))
+	var lookupPaths []string
+
+	for _, row := range rows {
+		if row.name == "" {
+			addError(row, "name", "name is required")
+		} else if strings.Contains(row.name, "/") {
+			addError(row, "name", "name cannot contain \"/\"")
+		}
+
+		if row.typ == "" {
+			addError(row, "type", "type is required")
+		} else if err := s.typeRules.ValidateType(row.typ); err != nil {
+			addError(row, "type", err.Error())
+		}
+
+		row.path = row.parentPath + "/" + row.name
+		row.depth = strings.Count(row.path, "/")
+
+		if other, ok := fileRows[row.path]; ok {
+			addError(row, "name", fmt.Sprintf("duplicate path %q, also on row %d", row.path, other.line))
+			continue
+		}
+		fileRows[row.path] = row
+		lookupPaths = append(lookupPaths, row.path)
+		if row.parentPath != "" {
+			lookupPaths = append(lookupPaths, row.parentPath)
+		}
+	}
+
+	existing, err := s.repository.GetStoragesByPaths(ctx, lookupPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	existingByPath := make(map[string][]*model.Storage, len(existing))
+	for _, storage := range existing {
+		existingByPath[storage.Path] = append(existingByPath[storage.Path], storage)
+	}
+
+	shelfTypes, err := s.shelfTypeRepository.GetShelfTypes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	shelfTypesByName := make(map[string][]primitive.ObjectID, len(shelfTypes))
+	shelfTypeSizes := make(map[primitive.ObjectID][2]*int, len(shelfTypes))
+	for _, shelfType := range shelfTypes {
+		name := strings.ToLower(strings.TrimSpace(shelfType.Name))
+		shelfTypesByName[name] = append(shelfTypesByName[name], shelfType.ID)
+		shelfTypeSizes[shelfType.ID] = [2]*int{shelfType.Slot, shelfType.Level}
+	}
+
+	sorted := make([]*importRow, len(rows))
+	copy(sorted, rows)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].depth < sorted[j].depth })
+
+	now := time.Now()
+	created := make(map[string]*model.Storage, len(rows))
+	failed := make(map[int]bool, len(result.Errors))
+	for _, rowError := range result.Errors {
+		failed[rowError.Row] = true
+	}
+
+	var storagies []*model.Storage
+
+	for _, row := range sorted {
+		if failed[row.line] {
+			continue
+		}
+
+		if len(existingByPath[row.path]) > 0 {
+			addError(row, "name", fmt.Sprintf("storage %q already exists", row.path))
+			failed[row.line] = true
+			continue
+		}
+
+		var parent *model.Storage
+		if row.parentPath != "" {
+			if parentRow, ok := fileRows[row.parentPath]; ok {
+				parent = created[row.parentPath]
+				if parent == nil {
+					addError(row, "parent_path", fmt.Sprintf("parent on row %d is invalid", parentRow.line))
+					failed[row.line] = true
+					continue
+				}
+			} else {
+				matches := existingByPath[row.parentPath]
+				if len(matches) == 0 {
+					addError(row, "parent_path", fmt.Sprintf("parent %q not found", row.parentPath))
+					failed[row.line] = true
+					continue
+				}
+				if len(matches) > 1 {
+					addError(row, "parent_path", fmt.Sprintf("parent %q is ambiguous, %d storages share this path", row.parentPath, len(matches)))
+					failed[row.line] = true
+					continue
+				}
+				parent = matches[0]
+			}
+		}
+
+		if err := s.typeRules.ValidateParent(row.typ, parent); err != nil {
+			addError(row, "type", err.Error())
+			failed[row.line] = true
+			continue
+		}
+
+		ID := primitive.NewObjectID()
+		description := row.description
+
+		storage := &model.Storage{
+			ID:          ID,
+			Name:        row.name,
+			Type:        row.typ,
+			QRCode:      constants.StorageQRPrefix + ID.Hex(),
+			Description: &description,
+			IsActive:    true,
+			CreatedBy:   userID,
+			CreatedAt:   now,
+			UpdatedAt:   now,
+		}
+
+		if parent != nil {
+			storage.ParentID = &parent.ID
+			applyParent(storage, parent)
+		} else {
+			storage.Level = 0
+			storage.Path = "/" + storage.Name
+			storage.AncestorIDs = []primitive.ObjectID{}
+		}
+
+		if row.shelfType != "" {
+			matches := shelfTypesByName[strings.ToLower(row.shelfType)]
+			if len(matches) == 0 {
+				addError(row, "shelf_type", fmt.Sprintf("shelf type %q not found", row.shelfType))
+				failed[row.line] = true
+				continue
+			}
+			if len(matches) > 1 {
+				addError(row, "shelf_type", fmt.Sprintf("shelf type %q is ambiguous", row.shelfType))
+				failed[row.line] = true
+				continue
+			}
+
+			shelfTypeID := matches[0]
+			size := shelfTypeSizes[shelfTypeID]
+			storage.ShelfTypeID = &shelfTypeID
+			storage.Slots = size[0]
+			storage.Levels = size[1]
+			if size[0] != nil && size[1] != nil {
+				totalStock := (*size[0]) * (*size[1])
+				storage.TotalStock = &totalStock
+			}
+		}
+
+		if err := s.typeRules.ValidateShelfFields(storage); err != nil {
+			addError(row, "shelf_type", err.Error())
+			failed[row.line] = true
+			continue
+		}
+
+		created[row.path] = storage
+		storagies = append(storagies, storage)
+		result.Items = append(result.Items, &ImportedStorage{
+			Row:  row.line,
+			ID:   storage.ID,
+			Path: storage.Path,
+			Type: storage.Type,
+		})
+	}
+
+	sort.SliceStable(result.Errors, func(i, j int) bool { return result.Errors[i].Row < result.Errors[j].Row })
+	sort.SliceStable(result.Items, func(i, j int) bool { return result.Items[i].Row < result.Items[j].Row })
+
+	return storagies, nil
+}
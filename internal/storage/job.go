@@ -0,0 +1,31 @@
+package storage
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// RunPurgeJob periodically hard deletes storages that have been archived for
+// longer than retention. It blocks until ctx is cancelled.
+func RunPurgeJob(ctx context.Context, service StorageService, retention, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := service.PurgeArchived(ctx, time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("Failed to purge archived storages: %v", err)
+		}
+		if purged > 0 {
+			log.Printf("Purged %d archived storages", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
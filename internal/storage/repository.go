@@ -3,23 +3,57 @@ package storage
 import (
 	"context"
 	"inventory-service/internal/shared/model"
+	"regexp"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+type StorageFilter struct {
+	Search          string
+	Type            string
+	ParentID        *primitive.ObjectID
+	AncestorID      *primitive.ObjectID
+	CreatedBy       string
+	IncludeArchived bool
+	SortField       string
+	SortOrder       int
+	Skip            int64
+	Limit           int64
+}
+
 type StorageRepository interface {
 	AddStorage(ctx context.Context, storage *model.Storage) (string, error)
-	GetStoragies(ctx context.Context, typeString string) (map[string][]*Storage, error)
-	GetAllStoragies(ctx context.Context) ([]*Storage, error)
+	AddStorages(ctx context.Context, storages []*model.Storage) error
+	GetStoragies(ctx context.Context, filter *StorageFilter) ([]*Storage, int64, error)
+	ForEachStorage(ctx context.Context, filter *StorageFilter, fn func(*Storage) error) error
+	GetTreeNodes(ctx context.Context, ancestorID *primitive.ObjectID, maxLevel int) ([]*Storage, error)
+	SumCapacityByStorageIDs(ctx context.Context, storageIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error)
+	CountChildren(ctx context.Context, parentIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error)
 	GetStorageByID(ctx context.Context, id *primitive.ObjectID) (*model.Storage, error)
+	GetStorageByIDWithArchived(ctx context.Context, id primitive.ObjectID) (*model.Storage, error)
+	GetStoragesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*model.Storage, error)
+	GetStoragesByPaths(ctx context.Context, paths []string) ([]*model.Storage, error)
 	GetStorageByShelfID(ctx context.Context, id primitive.ObjectID) ([]*model.Storage, error)
 	UpdateStorage(ctx context.Context, id primitive.ObjectID, storage *model.Storage) error
 	DeleteStorage(ctx context.Context, id primitive.ObjectID) error
 
+	// Hierarchy
+	GetStoragesByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) ([]*model.Storage, error)
+	UpdateHierarchies(ctx context.Context, storages []*model.Storage) error
+
+	// Archive
+	SetStoragesActive(ctx context.Context, ids []primitive.ObjectID, active bool, at time.Time) error
+	GetArchivedBefore(ctx context.Context, before time.Time) ([]*model.Storage, error)
+
 	// Update total stock
-	UpdateTotalStock(ctx context.Context, id primitive.ObjectID, totalStock int) error
+	IncrementTotalStock(ctx context.Context, id primitive.ObjectID, delta int) (bool, error)
+	SetTotalStock(ctx context.Context, id primitive.ObjectID, totalStock int) error
+	FreezeStorages(ctx context.Context, ids []primitive.ObjectID, countID primitive.ObjectID) (bool, error)
+	UnfreezeStorages(ctx context.Context, ids []primitive.ObjectID, countID primitive.ObjectID) error
 	CheckParentID(ctx context.Context, parentID primitive.ObjectID) (bool, error)
 	CheckShelfType(ctx context.Context, shelf_type_id primitive.ObjectID) (bool, error)
 }
@@ -46,6 +80,22 @@ func (r *storageRepository) AddStorage(ctx context.Context, storage *model.Stora
 	return oid.Hex(), err
 }
 
+func (r *storageRepository) AddStorages(ctx context.Context, storages []*model.Storage) error {
+
+	if len(storages) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, 0, len(storages))
+	for _, storage := range storages {
+		documents = append(documents, storage)
+	}
+
+	_, err := r.storageCollection.InsertMany(ctx, documents)
+	return err
+
+}
+
 func (r *storageRepository) GetStorageByID(ctx context.Context, id *primitive.ObjectID) (*model.Storage, error) {
 
 	var storage model.Storage
@@ -67,79 +117,185 @@ func (r *storageRepository) GetStorageByID(ctx context.Context, id *primitive.Ob
 
 }
 
-func (r *storageRepository) GetStoragies(ctx context.Context, typeString string) (map[string][]*Storage, error) {
+func (r *storageRepository) GetStorageByIDWithArchived(ctx context.Context, id primitive.ObjectID) (*model.Storage, error) {
 
-	pipeline := mongo.Pipeline{
-		{{Key: "$match", Value: bson.M{"is_actice": true}}},
-	}
+	var storage model.Storage
 
-	if typeString != "" {
-		pipeline = append(pipeline, bson.D{{Key: "$match", Value: bson.M{"type": typeString}}})
+	err := r.storageCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&storage)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, err
 	}
 
-	pipeline = append(pipeline,
-		bson.D{{Key: "$group", Value: bson.M{
-			"_id":   "$type",
-			"items": bson.M{"$push": "$$ROOT"},
-		}}},
-	)
+	return &storage, nil
 
-	cursor, err := r.storageCollection.Aggregate(ctx, pipeline)
+}
+
+func (r *storageRepository) GetStoragesByIDs(ctx context.Context, ids []primitive.ObjectID) ([]*model.Storage, error) {
+
+	if len(ids) == 0 {
+		return []*model.Storage{}, nil
+	}
+
+	cursor, err := r.storageCollection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}})
 	if err != nil {
 		return nil, err
 	}
 	defer cursor.Close(ctx)
 
-	typeMap := map[string]string{
-		"warehouse": "warehouses",
-		"building":  "buildings",
-		"floor":     "floors",
-		"room":      "rooms",
-		"shelf":     "shelves",
+	var storagies []*model.Storage
+	for cursor.Next(ctx) {
+		var storage model.Storage
+		if err := cursor.Decode(&storage); err != nil {
+			return nil, err
+		}
+		storagies = append(storagies, &storage)
+	}
+
+	return storagies, nil
+
+}
+
+func (r *storageRepository) GetStoragesByPaths(ctx context.Context, paths []string) ([]*model.Storage, error) {
+
+	if len(paths) == 0 {
+		return []*model.Storage{}, nil
 	}
 
-	results := make(map[string][]*Storage)
+	cursor, err := r.storageCollection.Find(ctx, bson.M{"path": bson.M{"$in": paths}, "is_actice": true})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var storagies []*model.Storage
 	for cursor.Next(ctx) {
-		var row struct {
-			ID    string     `bson:"_id"`
-			Items []*Storage `bson:"items"`
-		}
-		if err := cursor.Decode(&row); err != nil {
+		var storage model.Storage
+		if err := cursor.Decode(&storage); err != nil {
 			return nil, err
 		}
+		storagies = append(storagies, &storage)
+	}
 
-		if plural, ok := typeMap[row.ID]; ok {
-			results[plural] = row.Items
-		} else {
-			results[row.ID] = row.Items
+	return storagies, nil
+
+}
+
+func storageFilterQuery(filter *StorageFilter) bson.M {
+
+	query := bson.M{}
+	if !filter.IncludeArchived {
+		query["is_actice"] = true
+	}
+	if filter.Type != "" {
+		query["type"] = filter.Type
+	}
+	if filter.ParentID != nil {
+		query["parent_id"] = filter.ParentID
+	}
+	if filter.AncestorID != nil {
+		query["ancestor_ids"] = filter.AncestorID
+	}
+	if filter.CreatedBy != "" {
+		query["created_by"] = filter.CreatedBy
+	}
+	if filter.Search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(filter.Search), Options: "i"}
+		query["$or"] = bson.A{
+			bson.M{"name": pattern},
+			bson.M{"path": pattern},
 		}
 	}
 
-	allTypes := []string{"warehouses", "buildings", "floors", "rooms", "shelves"}
-	for _, t := range allTypes {
-		if _, ok := results[t]; !ok {
-			results[t] = []*Storage{}
+	return query
+}
+
+func (r *storageRepository) GetStoragies(ctx context.Context, filter *StorageFilter) ([]*Storage, int64, error) {
+
+	query := storageFilterQuery(filter)
+
+	total, err := r.storageCollection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: filter.SortField, Value: filter.SortOrder}, {Key: "_id", Value: 1}}).
+		SetSkip(filter.Skip).
+		SetLimit(filter.Limit)
+
+	cursor, err := r.storageCollection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	storagies := []*Storage{}
+	for cursor.Next(ctx) {
+		var storage Storage
+		if err := cursor.Decode(&storage); err != nil {
+			return nil, 0, err
 		}
+		storagies = append(storagies, &storage)
 	}
 
-	return results, nil
+	return storagies, total, nil
 
 }
 
-func (r *storageRepository) GetAllStoragies(ctx context.Context) ([]*Storage, error) {
+// ForEachStorage streams the storages matching filter to fn one at a time so
+// callers can process large hierarchies without loading them into memory.
+func (r *storageRepository) ForEachStorage(ctx context.Context, filter *StorageFilter, fn func(*Storage) error) error {
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: filter.SortField, Value: filter.SortOrder}, {Key: "_id", Value: 1}}).
+		SetBatchSize(500)
 
-	cursor, err := r.storageCollection.Find(ctx, bson.M{"is_actice": true})
+	cursor, err := r.storageCollection.Find(ctx, storageFilterQuery(filter), opts)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var storage Storage
+		if err := cursor.Decode(&storage); err != nil {
+			return err
+		}
+		if err := fn(&storage); err != nil {
+			return err
+		}
 	}
 
+	return cursor.Err()
+
+}
+
+// GetTreeNodes returns active storages below ancestorID (or every active
+// storage when it is nil) whose level does not exceed maxLevel. A negative
+// maxLevel disables the level limit.
+func (r *storageRepository) GetTreeNodes(ctx context.Context, ancestorID *primitive.ObjectID, maxLevel int) ([]*Storage, error) {
+
+	filter := bson.M{"is_actice": true}
+	if ancestorID != nil {
+		filter["ancestor_ids"] = ancestorID
+	}
+	if maxLevel >= 0 {
+		filter["level"] = bson.M{"$lte": maxLevel}
+	}
+
+	cursor, err := r.storageCollection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "level", Value: 1}, {Key: "name", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
 	defer cursor.Close(ctx)
 
 	var storagies []*Storage
 	for cursor.Next(ctx) {
 		var storage Storage
-		err := cursor.Decode(&storage)
-		if err != nil {
+		if err := cursor.Decode(&storage); err != nil {
 			return nil, err
 		}
 		storagies = append(storagies, &storage)
@@ -149,6 +305,90 @@ func (r *storageRepository) GetAllStoragies(ctx context.Context) ([]*Storage, er
 
 }
 
+// SumCapacityByStorageIDs returns the shelf capacity (slots * levels) held by
+// each given storage and everything below it, counting active shelves only.
+func (r *storageRepository) SumCapacityByStorageIDs(ctx context.Context, storageIDs []primitive.ObjectID) (map[primitive.ObjectID]int, error) {
+
+	totals := make(map[primitive.ObjectID]int)
+	if len(storageIDs) == 0 {
+		return totals, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"is_actice": true,
+			"slots":     bson.M{"$ne": nil},
+			"levels":    bson.M{"$ne": nil},
+			"$or": bson.A{
+				bson.M{"_id": bson.M{"$in": storageIDs}},
+				bson.M{"ancestor_ids": bson.M{"$in": storageIDs}},
+			},
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"capacity":    bson.M{"$multiply": bson.A{"$slots", "$levels"}},
+			"storage_ids": bson.M{"$concatArrays": bson.A{bson.M{"$ifNull": bson.A{"$ancestor_ids", bson.A{}}}, bson.A{"$_id"}}},
+		}}},
+		{{Key: "$unwind", Value: "$storage_ids"}},
+		{{Key: "$match", Value: bson.M{"storage_ids": bson.M{"$in": storageIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$storage_ids",
+			"total": bson.M{"$sum": "$capacity"},
+		}}},
+	}
+
+	cursor, err := r.storageCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Total int                `bson:"total"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		totals[row.ID] = row.Total
+	}
+
+	return totals, nil
+}
+
+func (r *storageRepository) CountChildren(ctx context.Context, parentIDs []primitive.ObjectID) (map[primitive.ObjectID]int64, error) {
+
+	counts := make(map[primitive.ObjectID]int64)
+	if len(parentIDs) == 0 {
+		return counts, nil
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"parent_id": bson.M{"$in": parentIDs}, "is_actice": true}}},
+		{{Key: "$group", Value: bson.M{"_id": "$parent_id", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := r.storageCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var row struct {
+			ID    primitive.ObjectID `bson:"_id"`
+			Count int64              `bson:"count"`
+		}
+		if err := cursor.Decode(&row); err != nil {
+			return nil, err
+		}
+		counts[row.ID] = row.Count
+	}
+
+	return counts, nil
+
+}
+
 func (r *storageRepository) UpdateStorage(ctx context.Context, id primitive.ObjectID, storage *model.Storage) error {
 
 	_, err := r.storageCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": storage})
@@ -195,14 +435,34 @@ func (r *storageRepository) GetStorageByShelfID(ctx context.Context, id primitiv
 
 }
 
-func (r *storageRepository) UpdateTotalStock(ctx context.Context, id primitive.ObjectID, totalStock int) error {
+func (r *storageRepository) SetTotalStock(ctx context.Context, id primitive.ObjectID, totalStock int) error {
+
+	_, err := r.storageCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"total_stock": totalStock, "updated_at": time.Now()}})
+	return err
+}
+
+// IncrementTotalStock atomically adds delta to a shelf's remaining capacity.
+// A negative delta only applies while enough capacity is left, otherwise it
+// reports false and nothing changes.
+func (r *storageRepository) IncrementTotalStock(ctx context.Context, id primitive.ObjectID, delta int) (bool, error) {
+
+	filter := bson.M{"_id": id}
+	if delta < 0 {
+		filter["is_actice"] = true
+		filter["total_stock"] = bson.M{"$gte": -delta}
+	}
 
-	_, err := r.storageCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"total_stock": totalStock}})
+	update := bson.M{
+		"$inc": bson.M{"total_stock": delta},
+		"$set": bson.M{"updated_at": time.Now()},
+	}
+
+	result, err := r.storageCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return result.MatchedCount > 0, nil
 }
 
 func (r *storageRepository) CheckParentID(ctx context.Context, parentID primitive.ObjectID) (bool, error) {
@@ -232,3 +492,136 @@ func (r *storageRepository) CheckShelfType(ctx context.Context, shelf_type_id pr
 	return true, nil
 
 }
+
+func (r *storageRepository) GetStoragesByParentIDs(ctx context.Context, parentIDs []primitive.ObjectID) ([]*model.Storage, error) {
+
+	cursor, err := r.storageCollection.Find(ctx, bson.M{"parent_id": bson.M{"$in": parentIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var storagies []*model.Storage
+	for cursor.Next(ctx) {
+		var storage model.Storage
+		if err := cursor.Decode(&storage); err != nil {
+			return nil, err
+		}
+		storagies = append(storagies, &storage)
+	}
+
+	return storagies, nil
+
+}
+
+func (r *storageRepository) UpdateHierarchies(ctx context.Context, storages []*model.Storage) error {
+
+	if len(storages) == 0 {
+		return nil
+	}
+
+	models := make([]mongo.WriteModel, 0, len(storages))
+	for _, storage := range storages {
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": storage.ID}).
+			SetUpdate(bson.M{"$set": bson.M{
+				"parent_id":    storage.ParentID,
+				"ancestor_ids": storage.AncestorIDs,
+				"level":        storage.Level,
+				"path":         storage.Path,
+				"updated_at":   storage.UpdatedAt,
+			}}))
+	}
+
+	_, err := r.storageCollection.BulkWrite(ctx, models)
+	return err
+
+}
+
+func (r *storageRepository) SetStoragesActive(ctx context.Context, ids []primitive.ObjectID, active bool, at time.Time) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var filter, update bson.M
+	if active {
+		filter = bson.M{"_id": bson.M{"$in": ids}, "is_actice": false}
+		update = bson.M{
+			"$set":   bson.M{"is_actice": true, "updated_at": at},
+			"$unset": bson.M{"archived_at": ""},
+		}
+	} else {
+		filter = bson.M{"_id": bson.M{"$in": ids}, "is_actice": true}
+		update = bson.M{
+			"$set": bson.M{"is_actice": false, "archived_at": at, "updated_at": at},
+		}
+	}
+
+	_, err := r.storageCollection.UpdateMany(ctx, filter, update)
+	return err
+
+}
+
+// FreezeStorages marks the storages as frozen by the given cycle count. It
+// reports false, changing nothing, when any of them is missing or already
+// frozen, so it must run in a transaction.
+func (r *storageRepository) FreezeStorages(ctx context.Context, ids []primitive.ObjectID, countID primitive.ObjectID) (bool, error) {
+
+	if len(ids) == 0 {
+		return true, nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "frozen_by": bson.M{"$exists": false}}
+	update := bson.M{"$set": bson.M{"frozen_by": countID, "updated_at": time.Now()}}
+
+	result, err := r.storageCollection.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return result.MatchedCount == int64(len(ids)), nil
+
+}
+
+// UnfreezeStorages clears the frozen mark of the storages still frozen by the
+// given cycle count.
+func (r *storageRepository) UnfreezeStorages(ctx context.Context, ids []primitive.ObjectID, countID primitive.ObjectID) error {
+
+	if len(ids) == 0 {
+		return nil
+	}
+
+	filter := bson.M{"_id": bson.M{"$in": ids}, "frozen_by": countID}
+	update := bson.M{"$unset": bson.M{"frozen_by": ""}, "$set": bson.M{"updated_at": time.Now()}}
+
+	_, err := r.storageCollection.UpdateMany(ctx, filter, update)
+	return err
+
+}
+
+func (r *storageRepository) GetArchivedBefore(ctx context.Context, before time.Time) ([]*model.Storage, error) {
+
+	filter := bson.M{
+		"is_actice":   false,
+		"archived_at": bson.M{"$lte": before},
+	}
+
+	cursor, err := r.storageCollection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var storagies []*model.Storage
+	for cursor.Next(ctx) {
+		var storage model.Storage
+		if err := cursor.Decode(&storage); err != nil {
+			return nil, err
+		}
+		storagies = append(storagies, &storage)
+	}
+
+	return storagies, nil
+
+}
@@ -25,3 +25,27 @@ type UpdateStorageRequest struct {
 	Slots       *int    `json:"slots"`
 	Levels      *int    `json:"levels"`
 }
+
+type MoveStorageRequest struct {
+	ParentID *string `json:"parent_id"`
+}
+
+type GetStoragiesQuery struct {
+	Page            int
+	Size            int
+	Search          string
+	Type            string
+	ParentID        string
+	AncestorID      string
+	CreatedBy       string
+	Sort            string
+	IncludeArchived bool
+}
+
+type ExportStoragesQuery struct {
+	Format            string
+	AncestorID        string
+	Type              string
+	IncludeArchived   bool
+	IncludePlacements bool
+}
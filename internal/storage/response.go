@@ -1,8 +1,62 @@
 package storage
 
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
 type StorageNodeResponse struct {
-	Storage      Storage                `json:"storage"`
-	ImageMainUrl string                 `json:"image_main_url"`
-	ImageMapUrl  string                 `json:"image_map_url"`
-	Children     []*StorageNodeResponse `json:"children"`
+	Storage       Storage                `json:"storage"`
+	ImageMainUrl  string                 `json:"image_main_url"`
+	ImageMapUrl   string                 `json:"image_map_url"`
+	ChildCount    int64                  `json:"child_count"`
+	TotalQuantity int                    `json:"total_quantity"`
+	Children      []*StorageNodeResponse `json:"children"`
+}
+
+type StorageListResponse struct {
+	Items      []*Storage `json:"items"`
+	Total      int64      `json:"total"`
+	Page       int        `json:"page"`
+	Size       int        `json:"size"`
+	TotalPages int        `json:"total_pages"`
+}
+
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+type ImportedStorage struct {
+	Row  int                `json:"row"`
+	ID   primitive.ObjectID `json:"id"`
+	Path string             `json:"path"`
+	Type string             `json:"type"`
+}
+
+type ImportResult struct {
+	DryRun  bool               `json:"dry_run"`
+	Total   int                `json:"total"`
+	Created int                `json:"created"`
+	Items   []*ImportedStorage `json:"items"`
+	Errors  []*ImportRowError  `json:"errors"`
+}
+
+type StorageUtilization struct {
+	ID          primitive.ObjectID `json:"id"`
+	Name        string             `json:"name"`
+	Type        string             `json:"type"`
+	Path        string             `json:"path"`
+	ShelfID     *string            `json:"shelf_id,omitempty"`
+	ImageMapUrl string             `json:"image_map_url,omitempty"`
+	Capacity    int                `json:"capacity"`
+	Occupied    int                `json:"occupied"`
+	Free        int                `json:"free"`
+	Percentage  float64            `json:"percentage"`
+}
+
+// UtilizationResponse describes a storage and its direct children. The
+// children's percentages can be drawn over the parent's floor plan
+// (image_map_url) as a heat map.
+type UtilizationResponse struct {
+	*StorageUtilization
+	Children []*StorageUtilization `json:"children"`
 }
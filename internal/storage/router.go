@@ -13,11 +13,18 @@ func RegisterRoutes(r *gin.Engine, handler *StorageHandler) {
 		location := api.Group("/storage").Use(middleware.Secured())
 		{
 			location.POST("", handler.CreateStorage)
+			location.POST("/import", handler.ImportStorages)
+			location.GET("/export", handler.ExportStorages)
 			location.GET("", handler.GetStoragies)
 			location.GET("/:id", handler.GetStorageByID)
 			location.PUT("/:id", handler.UpdateStorage)
-			location.DELETE("/:id", handler.DeleteStorage)
+			location.POST("/:id/move", handler.MoveStorage)
+			location.DELETE("/:id", handler.ArchiveStorage)
+			location.POST("/:id/archive", handler.ArchiveStorage)
+			location.POST("/:id/restore", handler.RestoreStorage)
 			location.GET("/tree", handler.GetStorageTree)
+			location.GET("/:id/children", handler.GetStorageChildren)
+			location.GET("/:id/utilization", handler.GetStorageUtilization)
 		}
 	}
 }
@@ -2,38 +2,67 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"inventory-service/internal/shared/model"
+	"inventory-service/internal/shared/ports"
 	shelfquantity "inventory-service/internal/shelf_quantity"
 	shelftype "inventory-service/internal/shelf_type"
+	"inventory-service/pkg/constants"
 	"inventory-service/pkg/uploader"
+	"io"
+	"log"
+	"sort"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type StorageService interface {
 	CreateStorage(ctx context.Context, req *CreateStorageRequest, userID string) (string, error)
-	GetStoragies(ctx context.Context, typeString string) (map[string][]*Storage, error)
+	GetStoragies(ctx context.Context, query *GetStoragiesQuery) (*StorageListResponse, error)
 	GetStorageByID(ctx context.Context, id string) (*model.Storage, error)
-	GetStorageTree(ctx context.Context) ([]*StorageNodeResponse, error)
+	GetStorageTree(ctx context.Context, depth int) ([]*StorageNodeResponse, error)
+	GetStorageChildren(ctx context.Context, id string, depth int) ([]*StorageNodeResponse, error)
 	UpdateStorage(ctx context.Context, id string, req *UpdateStorageRequest) error
-	DeleteStorage(ctx context.Context, id string) error
+	MoveStorage(ctx context.Context, id string, req *MoveStorageRequest) error
+	ArchiveStorage(ctx context.Context, id string) error
+	RestoreStorage(ctx context.Context, id string) error
+	PurgeArchived(ctx context.Context, before time.Time) (int, error)
+	ImportStorages(ctx context.Context, file io.Reader, fileName string, dryRun bool, userID string) (*ImportResult, error)
+	ExportStorages(ctx context.Context, query *ExportStoragesQuery, w io.Writer) error
+	GetStorageUtilization(ctx context.Context, id string) (*UtilizationResponse, error)
 }
 
 type storageService struct {
 	repository          StorageRepository
 	shelfTypeRepository shelftype.ShelfTypeRepository
 	shelfQuantityRepo   shelfquantity.ShelfQuantityRepository
+	placementRepo       ports.ProductPlacement
 	ImageService        uploader.ImageService
+	mongoClient         *mongo.Client
+	typeRules           *TypeRules
 }
 
-func NewStorageService(repository StorageRepository, shelfTypeRepository shelftype.ShelfTypeRepository, shelfQuantityRepo shelfquantity.ShelfQuantityRepository, imageService uploader.ImageService) StorageService {
+func NewStorageService(
+	repository StorageRepository,
+	shelfTypeRepository shelftype.ShelfTypeRepository,
+	shelfQuantityRepo shelfquantity.ShelfQuantityRepository,
+	placementRepo ports.ProductPlacement,
+	imageService uploader.ImageService,
+	mongoClient *mongo.Client,
+	typeRules *TypeRules,
+) StorageService {
 	return &storageService{
 		repository:          repository,
 		shelfTypeRepository: shelfTypeRepository,
 		shelfQuantityRepo:   shelfQuantityRepo,
+		placementRepo:       placementRepo,
 		ImageService:        imageService,
+		mongoClient:         mongoClient,
+		typeRules:           typeRules,
 	}
 }
 
@@ -56,9 +85,12 @@ func (s *storageService) CreateStorage(ctx context.Context, req *CreateStorageRe
 	if req.Type == "" {
 		return "", fmt.Errorf("type is required")
 	}
+	if err := s.typeRules.ValidateType(req.Type); err != nil {
+		return "", err
+	}
 
 	ID := primitive.NewObjectID()
-	qrCode := fmt.Sprintf("SENBOX.ORG[STORAGE]:%s", ID.Hex())
+	qrCode := constants.StorageQRPrefix + ID.Hex()
 
 	var storage *model.Storage
 
@@ -119,6 +151,10 @@ func (s *storageService) CreateStorage(ctx context.Context, req *CreateStorageRe
 		}
 	}
 
+	if err := s.validateStorageType(ctx, storage); err != nil {
+		return "", err
+	}
+
 	if err := s.buildLocationHierarchy(ctx, storage); err != nil {
 		return "", err
 	}
@@ -145,15 +181,248 @@ func (s *storageService) buildLocationHierarchy(ctx context.Context, storage *mo
 		return err
 	}
 
+	if parent == nil {
+		return fmt.Errorf("parent storage not found")
+	}
+
+	applyParent(storage, parent)
+
+	return nil
+}
+
+// validateStorageType enforces the type rules for the storage's parent and its
+// shelf specific fields.
+func (s *storageService) validateStorageType(ctx context.Context, storage *model.Storage) error {
+
+	if err := s.typeRules.ValidateType(storage.Type); err != nil {
+		return err
+	}
+
+	var parent *model.Storage
+	if storage.ParentID != nil {
+		var err error
+		parent, err = s.repository.GetStorageByID(ctx, storage.ParentID)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("parent storage not found")
+		}
+	}
+
+	if err := s.typeRules.ValidateParent(storage.Type, parent); err != nil {
+		return err
+	}
+
+	return s.typeRules.ValidateShelfFields(storage)
+}
+
+func (s *storageService) validateChildTypes(ctx context.Context, storage *model.Storage) error {
+
+	children, err := s.repository.GetStoragesByParentIDs(ctx, []primitive.ObjectID{storage.ID})
+	if err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := s.typeRules.ValidateParent(child.Type, storage); err != nil {
+			return fmt.Errorf("child %q: %w", child.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func applyParent(storage *model.Storage, parent *model.Storage) {
 	storage.Level = parent.Level + 1
 	storage.Path = parent.Path + "/" + storage.Name
-	storage.AncestorIDs = append(parent.AncestorIDs, parent.ID)
+	storage.AncestorIDs = append(append([]primitive.ObjectID{}, parent.AncestorIDs...), parent.ID)
+}
+
+// checkCycle walks up from the new parent and fails if it reaches the storage
+// being moved, i.e. the new parent lives inside the storage's own subtree.
+func (s *storageService) checkCycle(ctx context.Context, id primitive.ObjectID, parentID *primitive.ObjectID) error {
+
+	visited := make(map[primitive.ObjectID]bool)
+
+	for parentID != nil {
+		if *parentID == id {
+			return fmt.Errorf("cannot move storage under itself or its descendants")
+		}
+		if visited[*parentID] {
+			return fmt.Errorf("storage hierarchy contains a cycle")
+		}
+		visited[*parentID] = true
+
+		parent, err := s.repository.GetStorageByID(ctx, parentID)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return fmt.Errorf("parent storage not found")
+		}
+		parentID = parent.ParentID
+	}
 
 	return nil
 }
 
-func (s *storageService) GetStoragies(ctx context.Context, typeString string) (map[string][]*Storage, error) {
-	return s.repository.GetStoragies(ctx, typeString)
+// collectSubtree returns root followed by all of its descendants, archived ones
+// included, in breadth-first order so every parent precedes its children. The
+// subtree is walked through parent_id so it does not rely on ancestor_ids.
+func (s *storageService) collectSubtree(ctx context.Context, root *model.Storage) ([]*model.Storage, error) {
+
+	seen := map[primitive.ObjectID]bool{root.ID: true}
+	subtree := []*model.Storage{root}
+	frontier := []primitive.ObjectID{root.ID}
+
+	for len(frontier) > 0 {
+		children, err := s.repository.GetStoragesByParentIDs(ctx, frontier)
+		if err != nil {
+			return nil, err
+		}
+
+		frontier = nil
+		for _, child := range children {
+			if seen[child.ID] {
+				return nil, fmt.Errorf("storage hierarchy contains a cycle")
+			}
+			seen[child.ID] = true
+			subtree = append(subtree, child)
+			frontier = append(frontier, child.ID)
+		}
+	}
+
+	return subtree, nil
+}
+
+// cascadeHierarchy recomputes Level, Path and AncestorIDs for every descendant
+// of root and rewrites the denormalized copies held by product placements.
+func (s *storageService) cascadeHierarchy(ctx context.Context, root *model.Storage) error {
+
+	subtree, err := s.collectSubtree(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	nodes := make(map[primitive.ObjectID]*model.Storage, len(subtree))
+	for _, storage := range subtree {
+		if storage.ID != root.ID {
+			applyParent(storage, nodes[*storage.ParentID])
+			storage.UpdatedAt = now
+		}
+		nodes[storage.ID] = storage
+	}
+
+	if err := s.repository.UpdateHierarchies(ctx, subtree[1:]); err != nil {
+		return err
+	}
+
+	return s.placementRepo.UpdatePlacementLocations(ctx, subtree)
+}
+
+func (s *storageService) saveWithHierarchy(ctx context.Context, storage *model.Storage) error {
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		if err := s.repository.UpdateStorage(sc, storage.ID, storage); err != nil {
+			return nil, err
+		}
+		if err := s.cascadeHierarchy(sc, storage); err != nil {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	_, err = session.WithTransaction(ctx, callback)
+	return err
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 200
+)
+
+var storageSortFields = map[string]bool{
+	"name":       true,
+	"path":       true,
+	"type":       true,
+	"level":      true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+func (s *storageService) GetStoragies(ctx context.Context, query *GetStoragiesQuery) (*StorageListResponse, error) {
+
+	page := query.Page
+	if page < 1 {
+		page = 1
+	}
+
+	size := query.Size
+	if size < 1 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	filter := &StorageFilter{
+		Search:          query.Search,
+		Type:            query.Type,
+		CreatedBy:       query.CreatedBy,
+		IncludeArchived: query.IncludeArchived,
+		SortField:       "path",
+		SortOrder:       1,
+		Skip:            int64((page - 1) * size),
+		Limit:           int64(size),
+	}
+
+	if query.ParentID != "" {
+		parentID, err := primitive.ObjectIDFromHex(query.ParentID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid parent id: %w", err)
+		}
+		filter.ParentID = &parentID
+	}
+
+	if query.AncestorID != "" {
+		ancestorID, err := primitive.ObjectIDFromHex(query.AncestorID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ancestor id: %w", err)
+		}
+		filter.AncestorID = &ancestorID
+	}
+
+	if query.Sort != "" {
+		field := strings.TrimPrefix(query.Sort, "-")
+		if !storageSortFields[field] {
+			return nil, fmt.Errorf("invalid sort field %q", field)
+		}
+		filter.SortField = field
+		if strings.HasPrefix(query.Sort, "-") {
+			filter.SortOrder = -1
+		}
+	}
+
+	items, total, err := s.repository.GetStoragies(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StorageListResponse{
+		Items:      items,
+		Total:      total,
+		Page:       page,
+		Size:       size,
+		TotalPages: int((total + int64(size) - 1) / int64(size)),
+	}, nil
 }
 
 func (s *storageService) GetStorageByID(ctx context.Context, id string) (*model.Storage, error) {
@@ -167,49 +436,115 @@ func (s *storageService) GetStorageByID(ctx context.Context, id string) (*model.
 
 }
 
-func (s *storageService) GetStorageTree(ctx context.Context) ([]*StorageNodeResponse, error) {
+// GetStorageTree returns the hierarchy starting at the root storages. A depth
+// greater than zero limits how many levels are returned; zero returns all.
+func (s *storageService) GetStorageTree(ctx context.Context, depth int) ([]*StorageNodeResponse, error) {
+
+	if depth < 0 {
+		return nil, fmt.Errorf("depth must not be negative")
+	}
+
+	storagies, err := s.repository.GetTreeNodes(ctx, nil, depth-1)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.buildTree(ctx, storagies, nil)
+}
+
+// GetStorageChildren returns the subtree below a storage, depth levels deep.
+func (s *storageService) GetStorageChildren(ctx context.Context, id string, depth int) ([]*StorageNodeResponse, error) {
+
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+
+	if depth < 1 {
+		depth = 1
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	storage, err := s.repository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
 
-	storagies, err := s.repository.GetAllStoragies(ctx)
+	storagies, err := s.repository.GetTreeNodes(ctx, &objectID, storage.Level+depth)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.buildTree(ctx, storagies, &objectID)
+}
+
+// buildTree nests storagies under their parents and returns the nodes whose
+// parent is rootParentID. Every node carries its active child count and the
+// quantity held on it and below it.
+func (s *storageService) buildTree(ctx context.Context, storagies []*Storage, rootParentID *primitive.ObjectID) ([]*StorageNodeResponse, error) {
+
+	ids := make([]primitive.ObjectID, 0, len(storagies))
+	for _, storage := range storagies {
+		ids = append(ids, storage.ID)
+	}
+
+	childCounts, err := s.repository.CountChildren(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	quantities, err := s.placementRepo.SumQuantityByStorageIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageKeys []string
+	for _, storage := range storagies {
+		if storage.ImageMain != nil {
+			imageKeys = append(imageKeys, *storage.ImageMain)
+		}
+		if storage.ImageMap != nil {
+			imageKeys = append(imageKeys, *storage.ImageMap)
+		}
+	}
+
+	imageUrls := s.ImageService.GetImageKeys(ctx, imageKeys)
+
 	nodeMap := make(map[string]*StorageNodeResponse)
 
 	for _, storage := range storagies {
 		var imageMainUrl string
-		if storage.ImageMain != nil && *storage.ImageMain != "" {
-			urlImage, err := s.ImageService.GetImageKey(ctx, *storage.ImageMain)
-			if err == nil && urlImage != nil {
-				imageMainUrl = urlImage.Url
-			} else {
-				imageMainUrl = ""
-			}
+		if storage.ImageMain != nil {
+			imageMainUrl = imageUrls[*storage.ImageMain]
 		}
 
 		var imageMapUrl string
-		if storage.ImageMap != nil && *storage.ImageMap != "" {
-			urlImage, err := s.ImageService.GetImageKey(ctx, *storage.ImageMap)
-			if err == nil && urlImage != nil {
-				imageMapUrl = urlImage.Url
-			} else {
-				imageMapUrl = ""
-			}
+		if storage.ImageMap != nil {
+			imageMapUrl = imageUrls[*storage.ImageMap]
 		}
 
 		nodeMap[storage.ID.Hex()] = &StorageNodeResponse{
-			Storage:      *storage,
-			ImageMainUrl: imageMainUrl,
-			ImageMapUrl:  imageMapUrl,
-			Children:     []*StorageNodeResponse{},
+			Storage:       *storage,
+			ImageMainUrl:  imageMainUrl,
+			ImageMapUrl:   imageMapUrl,
+			ChildCount:    childCounts[storage.ID],
+			TotalQuantity: quantities[storage.ID],
+			Children:      []*StorageNodeResponse{},
 		}
 	}
 
-	var roots []*StorageNodeResponse
+	roots := []*StorageNodeResponse{}
 	for _, storage := range storagies {
-		if storage.ParentID == nil {
+		if sameParent(storage.ParentID, rootParentID) {
 			roots = append(roots, nodeMap[storage.ID.Hex()])
-		} else {
+		} else if storage.ParentID != nil {
 			parentNode, ok := nodeMap[storage.ParentID.Hex()]
 			if ok {
 				parentNode.Children = append(parentNode.Children, nodeMap[storage.ID.Hex()])
@@ -240,6 +575,10 @@ func (s *storageService) UpdateStorage(ctx context.Context, id string, req *Upda
 		return fmt.Errorf("storage not found")
 	}
 
+	oldPath := storage.Path
+	oldType := storage.Type
+	parentChanged := false
+
 	if req.Name != "" {
 		storage.Name = req.Name
 	}
@@ -252,37 +591,20 @@ func (s *storageService) UpdateStorage(ctx context.Context, id string, req *Upda
 		storage.Description = req.Description
 	}
 
-	if req.ImageMain != nil {
-		if storage.ImageMain != nil {
-			if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMain); err != nil {
-				return err
-			}
-		}
-		storage.ImageMain = req.ImageMain
-	}
-
-	if req.ImageMap != nil {
-		if storage.ImageMap != nil {
-			if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMap); err != nil {
-				return err
-			}
-		}
-		storage.ImageMap = req.ImageMap
-	}
-
 	if req.ParentID != nil {
-		if *req.ParentID == "" {
-			storage.ParentID = nil
-		} else {
-			parentID, err := primitive.ObjectIDFromHex(*req.ParentID)
-			if err != nil {
-				return fmt.Errorf("invalid parent id: %w", err)
-			}
-			storage.ParentID = &parentID
+		parentID, err := parseParentID(*req.ParentID)
+		if err != nil {
+			return err
 		}
-		if err := s.buildLocationHierarchy(ctx, storage); err != nil {
+		if err := s.checkCycle(ctx, storage.ID, parentID); err != nil {
 			return err
 		}
+		parentChanged = !sameParent(storage.ParentID, parentID)
+		storage.ParentID = parentID
+	}
+
+	if err := s.buildLocationHierarchy(ctx, storage); err != nil {
+		return err
 	}
 
 	if req.ShelfID != nil {
@@ -315,8 +637,61 @@ func (s *storageService) UpdateStorage(ctx context.Context, id string, req *Upda
 		storage.TotalStock = totalStock
 	}
 
+	// Storages created before the type rules may not satisfy them, so the
+	// rules are only enforced on what this update changes.
+	switch {
+	case storage.Type != oldType || parentChanged:
+		if err := s.validateStorageType(ctx, storage); err != nil {
+			return err
+		}
+	case req.ShelfTypeID != nil:
+		if err := s.typeRules.ValidateShelfFields(storage); err != nil {
+			return err
+		}
+	}
+
+	if storage.Type != oldType {
+		if err := s.validateChildTypes(ctx, storage); err != nil {
+			return err
+		}
+	}
+
+	// Stock on a storage whose new type cannot hold placements would be
+	// stranded there.
+	if storage.Type != oldType && !s.typeRules.CanHoldPlacements(storage.Type) {
+		stocked, err := s.placementRepo.CountStockedPlacements(ctx, []primitive.ObjectID{storage.ID})
+		if err != nil {
+			return err
+		}
+		if stocked > 0 {
+			return fmt.Errorf("storage type %q cannot hold stock, storage still holds stock in %d product placements", storage.Type, stocked)
+		}
+	}
+
+	if req.ImageMain != nil {
+		if storage.ImageMain != nil {
+			if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMain); err != nil {
+				return err
+			}
+		}
+		storage.ImageMain = req.ImageMain
+	}
+
+	if req.ImageMap != nil {
+		if storage.ImageMap != nil {
+			if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMap); err != nil {
+				return err
+			}
+		}
+		storage.ImageMap = req.ImageMap
+	}
+
 	storage.UpdatedAt = time.Now()
 
+	if parentChanged || storage.Path != oldPath {
+		return s.saveWithHierarchy(ctx, storage)
+	}
+
 	if err := s.repository.UpdateStorage(ctx, objectID, storage); err != nil {
 		return err
 	}
@@ -324,7 +699,83 @@ func (s *storageService) UpdateStorage(ctx context.Context, id string, req *Upda
 	return nil
 }
 
-func (s *storageService) DeleteStorage(ctx context.Context, id string) error {
+func (s *storageService) MoveStorage(ctx context.Context, id string, req *MoveStorageRequest) error {
+
+	if id == "" {
+		return fmt.Errorf("id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid id: %w", err)
+	}
+
+	storage, err := s.repository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return err
+	}
+
+	if storage == nil {
+		return fmt.Errorf("storage not found")
+	}
+
+	var parentID *primitive.ObjectID
+	if req.ParentID != nil {
+		parentID, err = parseParentID(*req.ParentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := s.checkCycle(ctx, storage.ID, parentID); err != nil {
+		return err
+	}
+
+	if !sameParent(storage.ParentID, parentID) {
+		storage.ParentID = parentID
+		if err := s.validateStorageType(ctx, storage); err != nil {
+			return err
+		}
+	}
+
+	if err := s.buildLocationHierarchy(ctx, storage); err != nil {
+		return err
+	}
+
+	storage.UpdatedAt = time.Now()
+
+	return s.saveWithHierarchy(ctx, storage)
+}
+
+func parseParentID(id string) (*primitive.ObjectID, error) {
+
+	if id == "" {
+		return nil, nil
+	}
+
+	parentID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parent id: %w", err)
+	}
+
+	return &parentID, nil
+}
+
+func sameParent(a, b *primitive.ObjectID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func (s *storageService) ArchiveStorage(ctx context.Context, id string) error {
 
 	if id == "" {
 		return fmt.Errorf("id is required")
@@ -340,37 +791,226 @@ func (s *storageService) DeleteStorage(ctx context.Context, id string) error {
 		return err
 	}
 
-	check, err := s.repository.CheckParentID(ctx, objectID)
+	if storage == nil {
+		return fmt.Errorf("storage not found")
+	}
 
+	subtree, err := s.collectSubtree(ctx, storage)
 	if err != nil {
 		return err
 	}
 
-	if check {
-		return fmt.Errorf("storage has children")
+	ids := storageIDs(subtree)
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	// Stock moving onto a shelf updates the shelf too, so it conflicts with
+	// this transaction instead of landing on an archived shelf.
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		stocked, err := s.placementRepo.CountStockedPlacements(sc, ids)
+		if err != nil {
+			return nil, err
+		}
+		if stocked > 0 {
+			return nil, fmt.Errorf("storage still holds stock in %d product placements", stocked)
+		}
+		return nil, s.repository.SetStoragesActive(sc, ids, false, time.Now())
+	}
+
+	_, err = session.WithTransaction(ctx, callback)
+	return err
+
+}
+
+func (s *storageService) RestoreStorage(ctx context.Context, id string) error {
+
+	if id == "" {
+		return fmt.Errorf("id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	storage, err := s.repository.GetStorageByIDWithArchived(ctx, objectID)
+	if err != nil {
+		return err
 	}
 
 	if storage == nil {
 		return fmt.Errorf("storage not found")
 	}
 
-	if storage.ShelfID != nil {
-		if err := s.shelfQuantityRepo.DeleteQuantity(ctx, storage.ID); err != nil {
+	if storage.IsActive {
+		return fmt.Errorf("storage is not archived")
+	}
+
+	if storage.ParentID != nil {
+		parent, err := s.repository.GetStorageByID(ctx, storage.ParentID)
+		if err != nil {
 			return err
 		}
+		if parent == nil {
+			return fmt.Errorf("parent storage is archived, restore it first")
+		}
 	}
 
-	if storage.ImageMain != nil {
-		if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMain); err != nil {
-			return err
+	subtree, err := s.collectSubtree(ctx, storage)
+	if err != nil {
+		return err
+	}
+
+	// Descendants archived on their own before the storage stay archived.
+	var restored []*model.Storage
+	for _, node := range subtree {
+		if node.ID == storage.ID || sameTime(node.ArchivedAt, storage.ArchivedAt) {
+			restored = append(restored, node)
 		}
 	}
-	if storage.ImageMap != nil {
-		if err := s.ImageService.DeleteImageKey(ctx, *storage.ImageMap); err != nil {
-			return err
+
+	return s.repository.SetStoragesActive(ctx, storageIDs(restored), true, time.Now())
+
+}
+
+// PurgeArchived hard deletes storages archived before the given time together
+// with their shelf quantities and empty placements. Each archive batch, a
+// storage archived on its own with the descendants archived along with it, is
+// purged in its own transaction, oldest first. A batch whose subtree still
+// holds a storage from another batch waits until that one is purged. Images
+// are kept because deleting them needs a user token; their keys are logged
+// instead.
+func (s *storageService) PurgeArchived(ctx context.Context, before time.Time) (int, error) {
+
+	storagies, err := s.repository.GetArchivedBefore(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	byID := make(map[primitive.ObjectID]*model.Storage, len(storagies))
+	for _, storage := range storagies {
+		byID[storage.ID] = storage
+	}
+
+	// A batch starts at a storage whose parent was not archived with it.
+	var roots []*model.Storage
+	for _, storage := range storagies {
+		if storage.ParentID != nil {
+			if parent := byID[*storage.ParentID]; parent != nil && sameTime(parent.ArchivedAt, storage.ArchivedAt) {
+				continue
+			}
 		}
+		roots = append(roots, storage)
 	}
 
-	return s.repository.DeleteStorage(ctx, objectID)
+	// Older batches first, and deeper ones first among equals, so a batch
+	// nested in a later one is gone before the later one is purged.
+	sort.Slice(roots, func(i, j int) bool {
+		if !roots[i].ArchivedAt.Equal(*roots[j].ArchivedAt) {
+			return roots[i].ArchivedAt.Before(*roots[j].ArchivedAt)
+		}
+		return roots[i].Level > roots[j].Level
+	})
+
+	purged := 0
+	var errs []error
+	for _, root := range roots {
+		batch, err := s.purgeBatch(ctx, root)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("purge archived storage %s: %w", root.ID.Hex(), err))
+			continue
+		}
+
+		for _, storage := range batch {
+			if storage.ImageMain != nil && *storage.ImageMain != "" {
+				log.Printf("purged storage %s left image %s", storage.ID.Hex(), *storage.ImageMain)
+			}
+			if storage.ImageMap != nil && *storage.ImageMap != "" {
+				log.Printf("purged storage %s left image %s", storage.ID.Hex(), *storage.ImageMap)
+			}
+		}
+		purged += len(batch)
+	}
+
+	return purged, errors.Join(errs...)
+
+}
+
+// purgeBatch deletes the archive batch rooted at root in one transaction and
+// returns the storages it deleted. It deletes nothing when root was restored
+// or re-archived since it was read, or when its subtree holds storages
+// archived at another time.
+func (s *storageService) purgeBatch(ctx context.Context, root *model.Storage) ([]*model.Storage, error) {
+
+	session, err := s.mongoClient.StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer session.EndSession(ctx)
+
+	callback := func(sc mongo.SessionContext) (interface{}, error) {
+		current, err := s.repository.GetStorageByIDWithArchived(sc, root.ID)
+		if err != nil {
+			return nil, err
+		}
+		if current == nil || current.IsActive || !sameTime(current.ArchivedAt, root.ArchivedAt) {
+			return []*model.Storage(nil), nil
+		}
+
+		subtree, err := s.collectSubtree(sc, current)
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range subtree {
+			if node.IsActive || !sameTime(node.ArchivedAt, current.ArchivedAt) {
+				return []*model.Storage(nil), nil
+			}
+		}
+
+		ids := storageIDs(subtree)
+
+		stocked, err := s.placementRepo.CountStockedPlacements(sc, ids)
+		if err != nil {
+			return nil, err
+		}
+		if stocked > 0 {
+			return nil, fmt.Errorf("archived storages still hold stock in %d product placements", stocked)
+		}
+
+		if err := s.placementRepo.DeletePlacementsByShelfIDs(sc, ids); err != nil {
+			return nil, err
+		}
+
+		for _, id := range ids {
+			if err := s.shelfQuantityRepo.DeleteQuantity(sc, id); err != nil {
+				return nil, err
+			}
+			if err := s.repository.DeleteStorage(sc, id); err != nil {
+				return nil, err
+			}
+		}
+
+		return subtree, nil
+	}
+
+	result, err := session.WithTransaction(ctx, callback)
+	if err != nil {
+		return nil, err
+	}
+
+	return result.([]*model.Storage), nil
+}
+
+func storageIDs(storagies []*model.Storage) []primitive.ObjectID {
+
+	ids := make([]primitive.ObjectID, 0, len(storagies))
+	for _, storage := range storagies {
+		ids = append(ids, storage.ID)
+	}
 
+	return ids
 }
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"fmt"
+	"inventory-service/config"
+	"inventory-service/internal/shared/model"
+	"strings"
+)
+
+type TypeRules struct {
+	rules map[string]config.StorageTypeRule
+}
+
+func NewTypeRules(rules []config.StorageTypeRule) *TypeRules {
+
+	typeRules := &TypeRules{
+		rules: make(map[string]config.StorageTypeRule, len(rules)),
+	}
+
+	for _, rule := range rules {
+		typeRules.rules[rule.Type] = rule
+	}
+
+	return typeRules
+}
+
+func (t *TypeRules) Types() []string {
+
+	types := make([]string, 0, len(t.rules))
+	for typ := range t.rules {
+		types = append(types, typ)
+	}
+
+	return types
+}
+
+func (t *TypeRules) ValidateType(typ string) error {
+
+	if _, ok := t.rules[typ]; !ok {
+		return fmt.Errorf("unknown storage type %q", typ)
+	}
+
+	return nil
+}
+
+// ValidateParent checks that a storage of type typ may be placed under parent.
+// A nil parent means the storage is a root node.
+func (t *TypeRules) ValidateParent(typ string, parent *model.Storage) error {
+
+	rule, ok := t.rules[typ]
+	if !ok {
+		return fmt.Errorf("unknown storage type %q", typ)
+	}
+
+	if parent == nil {
+		if !rule.AllowRoot {
+			return fmt.Errorf("storage type %q must have a parent", typ)
+		}
+		return nil
+	}
+
+	for _, parentType := range rule.ParentTypes {
+		if parentType == parent.Type {
+			return nil
+		}
+	}
+
+	if len(rule.ParentTypes) == 0 {
+		return fmt.Errorf("storage type %q cannot have a parent", typ)
+	}
+
+	return fmt.Errorf("storage type %q cannot be placed under %q, allowed parents: %s", typ, parent.Type, strings.Join(rule.ParentTypes, ", "))
+}
+
+func (t *TypeRules) ValidateShelfFields(storage *model.Storage) error {
+
+	if storage.ShelfTypeID == nil && storage.Slots == nil && storage.Levels == nil {
+		return nil
+	}
+
+	if !t.rules[storage.Type].AllowShelfType {
+		return fmt.Errorf("storage type %q cannot have a shelf type, slots or levels", storage.Type)
+	}
+
+	return nil
+}
+
+func (t *TypeRules) CanHoldPlacements(typ string) bool {
+	return t.rules[typ].AllowPlacement
+}
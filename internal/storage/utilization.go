@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"inventory-service/internal/shared/model"
+	"math"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// GetStorageUtilization rolls shelf capacity and placed quantity up to the
+// given storage and each of its direct children. Capacity is slots * levels of
+// every active shelf in the subtree, occupied is the placed quantity below it.
+func (s *storageService) GetStorageUtilization(ctx context.Context, id string) (*UtilizationResponse, error) {
+
+	if id == "" {
+		return nil, fmt.Errorf("id is required")
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id: %w", err)
+	}
+
+	storage, err := s.repository.GetStorageByID(ctx, &objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	if storage == nil {
+		return nil, fmt.Errorf("storage not found")
+	}
+
+	children, err := s.repository.GetStoragesByParentIDs(ctx, []primitive.ObjectID{objectID})
+	if err != nil {
+		return nil, err
+	}
+
+	activeChildren := make([]*model.Storage, 0, len(children))
+	for _, child := range children {
+		if child.IsActive {
+			activeChildren = append(activeChildren, child)
+		}
+	}
+
+	sort.Slice(activeChildren, func(i, j int) bool { return activeChildren[i].Name < activeChildren[j].Name })
+
+	ids := append([]primitive.ObjectID{objectID}, storageIDs(activeChildren)...)
+
+	capacities, err := s.repository.SumCapacityByStorageIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	quantities, err := s.placementRepo.SumQuantityByStorageIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var imageKeys []string
+	if storage.ImageMap != nil {
+		imageKeys = append(imageKeys, *storage.ImageMap)
+	}
+	for _, child := range activeChildren {
+		if child.ImageMap != nil {
+			imageKeys = append(imageKeys, *child.ImageMap)
+		}
+	}
+
+	imageUrls := s.ImageService.GetImageKeys(ctx, imageKeys)
+
+	response := &UtilizationResponse{
+		StorageUtilization: newStorageUtilization(storage, capacities[objectID], quantities[objectID], imageUrls),
+		Children:           make([]*StorageUtilization, 0, len(activeChildren)),
+	}
+
+	for _, child := range activeChildren {
+		response.Children = append(response.Children, newStorageUtilization(child, capacities[child.ID], quantities[child.ID], imageUrls))
+	}
+
+	return response, nil
+}
+
+func newStorageUtilization(storage *model.Storage, capacity, occupied int, imageUrls map[string]string) *StorageUtilization {
+
+	utilization := &StorageUtilization{
+		ID:       storage.ID,
+		Name:     storage.Name,
+		Type:     storage.Type,
+		Path:     storage.Path,
+		ShelfID:  storage.ShelfID,
+		Capacity: capacity,
+		Occupied: occupied,
+	}
+
+	if capacity > occupied {
+		utilization.Free = capacity - occupied
+	}
+
+	// Over-filled storages report more than 100% rather than being capped so
+	// they stand out on the heat map.
+	if capacity > 0 {
+		utilization.Percentage = math.Round(float64(occupied)/float64(capacity)*10000) / 100
+	}
+
+	if storage.ImageMap != nil {
+		utilization.ImageMapUrl = imageUrls[*storage.ImageMap]
+	}
+
+	return utilization
+}
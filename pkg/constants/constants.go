@@ -106,6 +106,9 @@ const (
 	MaximumUsageTime = "maximum_usage_time"
 
 	UserID = "user_id"
+
+	StorageQRPrefix   = "SENBOX.ORG[STORAGE]:"
+	InventoryQRPrefix = "SENBOX.ORG[INVENTORY]:"
 )
 
 type contextKey string
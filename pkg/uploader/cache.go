@@ -0,0 +1,116 @@
+package uploader
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	imageCacheTTL = 10 * time.Minute
+	// Signed URLs are dropped from the cache this long before they expire so
+	// clients never receive a link that dies in transit.
+	signedURLSafetyMargin = 30 * time.Second
+)
+
+type cachedImage struct {
+	url       string
+	expiresAt time.Time
+}
+
+type imageCache struct {
+	mu      sync.RWMutex
+	entries map[string]cachedImage
+	ttl     time.Duration
+}
+
+func newImageCache(ttl time.Duration) *imageCache {
+	return &imageCache{
+		entries: make(map[string]cachedImage),
+		ttl:     ttl,
+	}
+}
+
+func (c *imageCache) get(key string) (string, bool) {
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	if !ok {
+		return "", false
+	}
+
+	if time.Now().After(entry.expiresAt) {
+		c.mu.Lock()
+		delete(c.entries, key)
+		c.mu.Unlock()
+		return "", false
+	}
+
+	return entry.url, true
+}
+
+func (c *imageCache) set(key, rawURL string) {
+
+	now := time.Now()
+	expiresAt := now.Add(c.ttl)
+
+	if signedExpiry, ok := signedURLExpiry(rawURL); ok {
+		signedExpiry = signedExpiry.Add(-signedURLSafetyMargin)
+		if signedExpiry.Before(expiresAt) {
+			expiresAt = signedExpiry
+		}
+	}
+
+	if !expiresAt.After(now) {
+		return
+	}
+
+	c.mu.Lock()
+	c.entries[key] = cachedImage{url: rawURL, expiresAt: expiresAt}
+	c.mu.Unlock()
+}
+
+func (c *imageCache) delete(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// signedURLExpiry extracts the expiry of S3 (X-Amz-*), GCS (X-Goog-*) and
+// CloudFront style (Expires) signed URLs.
+func signedURLExpiry(rawURL string) (time.Time, bool) {
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	query := u.Query()
+
+	if expires := query.Get("Expires"); expires != "" {
+		if seconds, err := strconv.ParseInt(expires, 10, 64); err == nil {
+			return time.Unix(seconds, 0), true
+		}
+	}
+
+	for _, prefix := range []string{"X-Amz-", "X-Goog-"} {
+		date, expires := query.Get(prefix+"Date"), query.Get(prefix+"Expires")
+		if date == "" || expires == "" {
+			continue
+		}
+		signedAt, err := time.Parse("20060102T150405Z", date)
+		if err != nil {
+			continue
+		}
+		seconds, err := strconv.Atoi(expires)
+		if err != nil {
+			continue
+		}
+		return signedAt.Add(time.Duration(seconds) * time.Second), true
+	}
+
+	return time.Time{}, false
+}
@@ -8,6 +8,7 @@ import (
 	"inventory-service/pkg/consul"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/consul/api"
@@ -23,11 +24,15 @@ type ImageKey struct {
 
 type ImageService interface {
 	GetImageKey(ctx context.Context, key string) (*Avatar, error)
+	// GetImageKeys resolves many keys at once. Keys that cannot be resolved map
+	// to an empty URL instead of failing the whole batch.
+	GetImageKeys(ctx context.Context, keys []string) map[string]string
 	DeleteImageKey(ctx context.Context, key string) error
 }
 
 type imageService struct {
 	client *callAPI
+	cache  *imageCache
 }
 
 type callAPI struct {
@@ -39,10 +44,13 @@ var (
 	imageServiceStr = "go-main-service"
 )
 
+const imageLookupConcurrency = 8
+
 func NewImageService(client *api.Client) ImageService {
 	mainServiceAPI := NewServiceAPI(client, imageServiceStr)
 	return &imageService{
 		client: mainServiceAPI,
+		cache:  newImageCache(imageCacheTTL),
 	}
 }
 
@@ -81,6 +89,10 @@ func NewServiceAPI(client *api.Client, serviceName string) *callAPI {
 
 func (s *imageService) GetImageKey(ctx context.Context, key string) (*Avatar, error) {
 
+	if url, ok := s.cache.get(key); ok {
+		return &Avatar{Url: url}, nil
+	}
+
 	token, ok := ctx.Value(constants.TokenKey).(string)
 	if !ok {
 		return nil, fmt.Errorf("token not found in context")
@@ -100,14 +112,71 @@ func (s *imageService) GetImageKey(ctx context.Context, key string) (*Avatar, er
 		return nil, nil
 	}
 
+	s.cache.set(key, innerData)
+
 	return &Avatar{
 		Url: innerData,
 	}, nil
 
 }
 
+func (s *imageService) GetImageKeys(ctx context.Context, keys []string) map[string]string {
+
+	urls := make(map[string]string, len(keys))
+	var pending []string
+
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if _, seen := urls[key]; seen {
+			continue
+		}
+		if url, ok := s.cache.get(key); ok {
+			urls[key] = url
+			continue
+		}
+		urls[key] = ""
+		pending = append(pending, key)
+	}
+
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, imageLookupConcurrency)
+	)
+
+	for _, key := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			image, err := s.GetImageKey(ctx, key)
+			if err != nil {
+				fmt.Printf("Error resolving image %s: %v\n", key, err)
+				return
+			}
+			if image == nil {
+				return
+			}
+
+			mu.Lock()
+			urls[key] = image.Url
+			mu.Unlock()
+		}(key)
+	}
+
+	wg.Wait()
+
+	return urls
+}
+
 func (s *imageService) DeleteImageKey(ctx context.Context, key string) error {
 
+	s.cache.delete(key)
+
 	token, ok := ctx.Value(constants.TokenKey).(string)
 	if !ok {
 		return fmt.Errorf("token not found in context")